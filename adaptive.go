@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Adaptive raise the number of probes in flight additively while
+//the network keeps up, and halve it when timeouts or connect rtt grow
+type Adaptive struct {
+	Enabled         bool    `json:"enabled"`
+	Min             int     `json:"min"`
+	Max             int     `json:"max"`
+	Step            int     `json:"step"`
+	Interval        int     `json:"interval"`
+	MaxTimeoutRatio float64 `json:"max_timeout_ratio"`
+	RTTFactor       float64 `json:"rtt_factor"`
+}
+
+//adaptiveMinWindow the number of connected probes a window needs to be
+//judged
+const adaptiveMinWindow = 10
+
+//concurrencyController an AIMD controller of the probes in flight
+type concurrencyController struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	inFlight int
+
+	//current window, only probes whose connect succeeded are counted, most
+	//of the scanned ranges time out on connect whatever the concurrency
+	start     time.Time
+	connected int
+	timeouts  int
+	rttSum    int
+	//lowest average connect rtt of a window
+	baseRTT int
+}
+
+//controller is nil if adaptive concurrency is disabled
+var controller *concurrencyController
+
+//initAdaptive check the bounds and start at the lower one, the upper bound
+//replaces concurrency
+func initAdaptive() {
+	c := &config.Adaptive
+	if !c.Enabled {
+		return
+	}
+	if c.Max <= 0 {
+		c.Max = config.Concurrency
+	}
+	if c.Min <= 0 {
+		c.Min = 1
+	}
+	if c.Min > c.Max {
+		checkErr("parse config file error: ", fmt.Errorf("adaptive_concurrency min %d is above max %d", c.Min, c.Max), Error)
+	}
+	if c.Step <= 0 {
+		c.Step = 1
+	}
+	if c.Interval <= 0 {
+		c.Interval = 5
+	}
+	if c.MaxTimeoutRatio <= 0 {
+		c.MaxTimeoutRatio = 0.2
+	}
+	if c.RTTFactor <= 1 {
+		c.RTTFactor = 2
+	}
+	config.Concurrency = c.Max
+
+	controller = &concurrencyController{limit: c.Min, start: time.Now()}
+	controller.cond = sync.NewCond(&controller.mu)
+	checkErr(fmt.Sprintf("concurrency: %d", c.Min), errors.New(""), Info)
+}
+
+//acquire block until another probe may start
+func (cc *concurrencyController) acquire() {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	for cc.inFlight >= cc.limit {
+		cc.cond.Wait()
+	}
+	cc.inFlight++
+	cc.mu.Unlock()
+}
+
+//release end a probe with the checked ip and adjust the limit once the
+//interval has passed
+func (cc *concurrencyController) release(ip IP) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.inFlight--
+	if ip.ConnectDelay > 0 {
+		cc.connected++
+		cc.rttSum += ip.ConnectDelay
+		if ip.FailureClass == failTimeout {
+			cc.timeouts++
+		}
+	}
+
+	c := config.Adaptive
+	if time.Since(cc.start) >= time.Duration(c.Interval)*time.Second && cc.connected >= adaptiveMinWindow {
+		ratio := float64(cc.timeouts) / float64(cc.connected)
+		rtt := cc.rttSum / cc.connected
+		if cc.baseRTT == 0 || rtt < cc.baseRTT {
+			cc.baseRTT = rtt
+		}
+		limit := cc.limit
+		if ratio > c.MaxTimeoutRatio || float64(rtt) > float64(cc.baseRTT)*c.RTTFactor {
+			limit /= 2
+		} else {
+			limit += c.Step
+		}
+		if limit < c.Min {
+			limit = c.Min
+		}
+		if limit > c.Max {
+			limit = c.Max
+		}
+		if limit != cc.limit {
+			checkErr(fmt.Sprintf("concurrency: %d -> %d (timeout ratio: %.2f, connect rtt: %dms)", cc.limit, limit, ratio, rtt), errors.New(""), Info)
+			cc.limit = limit
+		}
+		cc.start = time.Now()
+		cc.connected, cc.timeouts, cc.rttSum = 0, 0, 0
+	}
+	cc.cond.Broadcast()
+}
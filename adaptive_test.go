@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//windowIPs n checked ips with connect rtt and failure class
+func windowIPs(n, rtt int, class string) []IP {
+	ips := make([]IP, n)
+	for i := range ips {
+		ips[i] = IP{ConnectDelay: rtt, FailureClass: class}
+	}
+	return ips
+}
+
+func TestConcurrencyControllerRelease(t *testing.T) {
+	saved := config.Adaptive
+	defer func() {
+		config.Adaptive = saved
+	}()
+	config.Adaptive = Adaptive{Enabled: true, Min: 10, Max: 40, Step: 5, Interval: 5, MaxTimeoutRatio: 0.2, RTTFactor: 2}
+
+	tests := []struct {
+		name    string
+		limit   int
+		baseRTT int
+		window  [][]IP
+		want    int
+	}{
+		{"increase", 20, 50, [][]IP{windowIPs(10, 50, "")}, 25},
+		{"decrease on timeouts", 20, 50, [][]IP{windowIPs(3, 50, failTimeout), windowIPs(7, 50, "")}, 10},
+		{"decrease on connect rtt", 30, 50, [][]IP{windowIPs(10, 150, "")}, 15},
+		{"rtt below the factor", 20, 50, [][]IP{windowIPs(10, 90, "")}, 25},
+		{"dead ips are not counted", 20, 50, [][]IP{windowIPs(100, 0, failTimeout), windowIPs(10, 50, "")}, 25},
+		{"too few connected probes", 20, 50, [][]IP{windowIPs(100, 0, failTimeout), windowIPs(9, 50, failTimeout)}, 20},
+		{"clamped to min", 12, 50, [][]IP{windowIPs(10, 50, failTimeout)}, 10},
+		{"clamped to max", 38, 50, [][]IP{windowIPs(10, 50, "")}, 40},
+	}
+	for _, tt := range tests {
+		cc := &concurrencyController{limit: tt.limit, baseRTT: tt.baseRTT, start: time.Now().Add(-time.Minute)}
+		cc.cond = sync.NewCond(&cc.mu)
+		for _, ips := range tt.window {
+			for _, ip := range ips {
+				cc.inFlight++
+				cc.release(ip)
+			}
+		}
+		if cc.limit != tt.want {
+			t.Errorf("%s: limit = %d, want %d", tt.name, cc.limit, tt.want)
+		}
+		if cc.inFlight != 0 {
+			t.Errorf("%s: %d probes in flight after release", tt.name, cc.inFlight)
+		}
+	}
+}
+
+func TestConcurrencyControllerInterval(t *testing.T) {
+	saved := config.Adaptive
+	defer func() {
+		config.Adaptive = saved
+	}()
+	config.Adaptive = Adaptive{Enabled: true, Min: 10, Max: 40, Step: 5, Interval: 5, MaxTimeoutRatio: 0.2, RTTFactor: 2}
+
+	//the window is not judged before the interval has passed
+	cc := &concurrencyController{limit: 20, start: time.Now()}
+	cc.cond = sync.NewCond(&cc.mu)
+	for _, ip := range windowIPs(50, 50, "") {
+		cc.inFlight++
+		cc.release(ip)
+	}
+	if cc.limit != 20 || cc.connected != 50 {
+		t.Errorf("limit = %d with %d connected probes, want 20 with 50", cc.limit, cc.connected)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+//bindToInterface bind the sockets of uplink to its interface with
+//SO_BINDTODEVICE
+func bindToInterface(uplink *Uplink) error {
+	iface := uplink.Interface
+	uplink.control = func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+//bindToInterface use the first address of the interface of uplink as source
+//ip, as SO_BINDTODEVICE is only available on linux
+func bindToInterface(uplink *Uplink) error {
+	if uplink.localIP != nil {
+		return nil
+	}
+	iface, err := net.InterfaceByName(uplink.Interface)
+	if err != nil {
+		return err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			uplink.localIP = ipNet.IP
+			return nil
+		}
+	}
+	return fmt.Errorf("interface %s has no usable address", uplink.Interface)
+}
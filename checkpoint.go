@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const checkpointFileName = "checkpoint.json"
+
+var resumeScan = flag.Bool("resume", false, "continue the soft mode scan from "+checkpointFileName)
+
+//scanPos the position of an ip in the scan order of googleip.txt
+type scanPos struct {
+	Index int `json:"index"`
+}
+
+//Checkpoint the position before which every ip of a soft mode scan has been
+//checked. It only applies to the googleip.txt and config it was made with,
+//and to the seeds of a random scan order and of the ipv6 sampling.
+type Checkpoint struct {
+	scanPos
+	//Done the positions after scanPos which are checked too
+	Done []int `json:"done,omitempty"`
+	//LastOkIPs the ips of check_last_okip which are not checked yet
+	LastOkIPs  []string  `json:"last_ok_ips,omitempty"`
+	Seed       int64     `json:"seed,omitempty"`
+	IPv6Seed   int64     `json:"ipv6_seed"`
+	RangesHash string    `json:"ranges_hash"`
+	ConfigHash string    `json:"config_hash"`
+	Time       time.Time `json:"time"`
+}
+
+//queuedIP an ip to check, seq is its number in the scan tracker or -1 if
+//it is not tracked, lastOk marks the ips of check_last_okip
+type queuedIP struct {
+	Address string
+	seq     int
+	lastOk  bool
+}
+
+//trackedIP an ip emitted in soft mode which is not done yet
+type trackedIP struct {
+	pos       scanPos
+	remaining int
+	cancelled bool
+}
+
+//scanTracker follow the ips of a soft mode scan in order, to find the
+//position before which all of them are done
+type scanTracker struct {
+	mu      sync.Mutex
+	base    int
+	pending []trackedIP
+	next    scanPos
+	//lastOk the ips of check_last_okip which are not done
+	lastOk map[string]*trackedIP
+}
+
+//tracker is nil unless checkpoints are written
+var tracker *scanTracker
+
+//resumed the checkpoint the scan starts at
+var resumed Checkpoint
+
+//resumedDone the positions after the checkpoint which are checked
+var resumedDone map[int]bool
+
+//fileHash return the hex sha256 of file
+func fileHash(file string) string {
+	data, err := ioutil.ReadFile(file)
+	checkErr(fmt.Sprintf("read file %s error: ", file), err, Error)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//initCheckpoint load the checkpoint with -resume and start tracking the
+//scan, it must be called after the flags are parsed
+func initCheckpoint() {
+	if !config.SoftMode {
+		if *resumeScan {
+			checkErr("resume error: ", fmt.Errorf("checkpoints are only written in soft mode"), Warning)
+			*resumeScan = false
+		}
+		return
+	}
+	if *resumeScan {
+		resumed, *resumeScan = loadCheckpoint()
+	}
+	if *resumeScan {
+		if config.ScanOrder.Random {
+			setScanSeed(resumed.Seed)
+		}
+		seedIPv6(resumed.IPv6Seed)
+		resumedDone = make(map[int]bool)
+		for _, index := range resumed.Done {
+			resumedDone[index] = true
+		}
+	}
+	if config.CheckpointEvery > 0 {
+		tracker = &scanTracker{next: resumed.scanPos, lastOk: make(map[string]*trackedIP)}
+	}
+}
+
+//loadCheckpoint return the checkpoint, ok is false if there is none or it
+//was made with another googleip.txt or config
+func loadCheckpoint() (cp Checkpoint, ok bool) {
+	data, err := ioutil.ReadFile(checkpointFileName)
+	if err != nil {
+		checkErr("resume error: ", err, Warning)
+		return Checkpoint{}, false
+	}
+	if err = json.Unmarshal(data, &cp); err != nil {
+		checkErr("resume error: ", err, Warning)
+		return Checkpoint{}, false
+	}
+	if cp.RangesHash != fileHash(googleIPFileName) {
+		checkErr("resume error: ", fmt.Errorf("%s changed, start over", googleIPFileName), Warning)
+		return Checkpoint{}, false
+	}
+	if cp.ConfigHash != fileHash(configFileName) {
+		checkErr("resume error: ", fmt.Errorf("%s changed, start over", configFileName), Warning)
+		return Checkpoint{}, false
+	}
+	return cp, true
+}
+
+//saveCheckpoint write the position before which all ips are done
+func saveCheckpoint() {
+	pos, done, lastOk := tracker.state()
+	cp := Checkpoint{
+		scanPos:    pos,
+		Done:       done,
+		LastOkIPs:  lastOk,
+		Seed:       scanSeed,
+		IPv6Seed:   ipv6Seed,
+		RangesHash: fileHash(googleIPFileName),
+		ConfigHash: fileHash(configFileName),
+		Time:       time.Now(),
+	}
+	data, err := json.MarshalIndent(cp, "", "    ")
+	checkErr("marshal checkpoint error: ", err, Warning)
+	//a crash while writing must not leave a broken checkpoint
+	tmp := checkpointFileName + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, checkpointFileName)
+	}
+	checkErr(fmt.Sprintf("write file %s error: ", checkpointFileName), err, Warning)
+}
+
+//saveCheckpoints write a checkpoint every checkpoint_interval seconds until
+//stop is closed
+func saveCheckpoints(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(config.CheckpointEvery) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			saveCheckpoint()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//sortedGoogleIPRange return the unique ranges of googleip.txt in a stable
+//order, so positions stay valid across runs
+func sortedGoogleIPRange() []string {
+	ipRanges := convertMap2Array(convertArray2Map(getGoogleIPRange()))
+	sort.Strings(ipRanges)
+	return ipRanges
+}
+
+//emit track the ip at pos, it returns the seq of the ip or -1 if the scan
+//is not tracked
+func (t *scanTracker) emit(pos scanPos) int {
+	if t == nil {
+		return -1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, trackedIP{pos: pos, remaining: len(uplinks)})
+	t.next = scanPos{Index: pos.Index + 1}
+	return t.base + len(t.pending) - 1
+}
+
+//trackLastOk track the ips of check_last_okip
+func (t *scanTracker) trackLastOk(ips []string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ip := range ips {
+		t.lastOk[ip] = &trackedIP{remaining: len(uplinks)}
+	}
+}
+
+//finish record that ip is done over one uplink, checked is false if its
+//probe was cancelled
+func (t *scanTracker) finish(ip queuedIP, checked bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var tracked *trackedIP
+	if ip.lastOk {
+		tracked = t.lastOk[ip.Address]
+	} else if ip.seq >= 0 {
+		tracked = &t.pending[ip.seq-t.base]
+	}
+	if tracked == nil {
+		return
+	}
+	tracked.remaining--
+	if !checked {
+		tracked.cancelled = true
+	}
+	if ip.lastOk && tracked.remaining == 0 && !tracked.cancelled {
+		delete(t.lastOk, ip.Address)
+	}
+	for len(t.pending) > 0 && t.pending[0].remaining == 0 && !t.pending[0].cancelled {
+		t.pending = t.pending[1:]
+		t.base++
+	}
+}
+
+//state return the position of the first ip which is not done, the
+//positions after it which are done and the last ok ips which are not done
+func (t *scanTracker) state() (pos scanPos, done []int, lastOk []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pos = t.next
+	if len(t.pending) > 0 {
+		pos = t.pending[0].pos
+	}
+	for _, ip := range t.pending {
+		if ip.remaining == 0 && !ip.cancelled {
+			done = append(done, ip.pos.Index)
+		}
+	}
+	//the positions done before the resume were never emitted
+	for index := range resumedDone {
+		if index >= pos.Index {
+			done = append(done, index)
+		}
+	}
+	sort.Ints(done)
+	for ip := range t.lastOk {
+		lastOk = append(lastOk, ip)
+	}
+	sort.Strings(lastOk)
+	return pos, done, lastOk
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScanTracker(t *testing.T) {
+	savedUplinks, savedDone := uplinks, resumedDone
+	defer func() {
+		uplinks, resumedDone = savedUplinks, savedDone
+	}()
+	uplinks = []*Uplink{{Name: "a"}, {Name: "b"}}
+	//12 and 20 were checked before the resume
+	resumedDone = map[int]bool{12: true, 20: true}
+
+	tracker := &scanTracker{next: scanPos{Index: 10}, lastOk: make(map[string]*trackedIP)}
+	tracker.trackLastOk([]string{"1.1.1.1", "2.2.2.2"})
+	var ips []queuedIP
+	for _, index := range []int{10, 11, 13, 14} {
+		ips = append(ips, queuedIP{Address: "ip", seq: tracker.emit(scanPos{Index: index})})
+	}
+
+	finish := func(ip queuedIP, checked ...bool) {
+		for _, c := range checked {
+			tracker.finish(ip, c)
+		}
+	}
+	finish(ips[1], true, true)
+	finish(ips[2], true, false)
+	finish(ips[3], true)
+	finish(queuedIP{Address: "1.1.1.1", seq: -1, lastOk: true}, true, true)
+	finish(queuedIP{Address: "2.2.2.2", seq: -1, lastOk: true}, true, false)
+
+	pos, done, lastOk := tracker.state()
+	if pos.Index != 10 {
+		t.Errorf("position = %d, want 10", pos.Index)
+	}
+	//13 was cancelled over one uplink and 14 is still running over the other
+	if want := []int{11, 12, 20}; !reflect.DeepEqual(done, want) {
+		t.Errorf("done = %v, want %v", done, want)
+	}
+	if want := []string{"2.2.2.2"}; !reflect.DeepEqual(lastOk, want) {
+		t.Errorf("last ok = %v, want %v", lastOk, want)
+	}
+
+	finish(ips[0], true, true)
+	finish(ips[3], true)
+	pos, done, _ = tracker.state()
+	if pos.Index != 13 {
+		t.Errorf("position = %d, want 13", pos.Index)
+	}
+	if want := []int{14, 20}; !reflect.DeepEqual(done, want) {
+		t.Errorf("done = %v, want %v", done, want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//regexPrefix marks a rule pattern as regular expression instead of glob
+const regexPrefix = "re:"
+
+//Rule classify an ip as Class if all conditions of the rule match. A
+//condition matches if any of its patterns does, empty conditions are
+//ignored. Patterns are globs, or regular expressions with the "re:" prefix.
+type Rule struct {
+	Class        string   `json:"class"`
+	CommonName   []string `json:"cn"`
+	SAN          []string `json:"san"`
+	Organization []string `json:"organization"`
+	Issuer       []string `json:"issuer"`
+	ALPN         []string `json:"alpn"`
+	HTTPStatus   []int    `json:"http_status"`
+	HTTPServer   []string `json:"http_server"`
+
+	commonName   []*regexp.Regexp
+	san          []*regexp.Regexp
+	organization []*regexp.Regexp
+	issuer       []*regexp.Regexp
+	alpn         []*regexp.Regexp
+	httpServer   []*regexp.Regexp
+}
+
+var rules []*Rule
+
+//ruleClasses the class names in rule order
+var ruleClasses []string
+
+//initRules compile the rules, without rules config they are made of the
+//organization, gws, gvs and match_ip_by_dnsname options
+func initRules() {
+	configured := config.Rules
+	if len(configured) == 0 {
+		configured = legacyRules()
+	}
+	seen := make(map[string]bool)
+	for i := range configured {
+		rule := configured[i]
+		if rule.Class == "" || strings.ContainsAny(rule.Class, " \t") {
+			checkErr("parse config file error: ", fmt.Errorf("rule %d needs a class name without spaces", i), Error)
+		}
+		rule.commonName = compilePatterns(rule.CommonName)
+		rule.san = compilePatterns(rule.SAN)
+		rule.organization = compilePatterns(rule.Organization)
+		rule.issuer = compilePatterns(rule.Issuer)
+		rule.alpn = compilePatterns(rule.ALPN)
+		rule.httpServer = compilePatterns(rule.HTTPServer)
+		rules = append(rules, &rule)
+		if !seen[rule.Class] {
+			seen[rule.Class] = true
+			ruleClasses = append(ruleClasses, rule.Class)
+		}
+	}
+}
+
+//legacyRules build the rules equal to the organization, gws, gvs and
+//match_ip_by_dnsname options
+func legacyRules() []Rule {
+	var legacy []Rule
+	var organization []string
+	for _, org := range config.OrgNames {
+		organization = append(organization, regexPrefix+"^"+regexp.QuoteMeta(org)+"$")
+	}
+	for _, class := range []struct {
+		name    string
+		domains []string
+	}{{"gws", config.GwsDomains}, {"gvs", config.GvsDomains}} {
+		for _, domain := range class.domains {
+			rule := Rule{Class: class.name, Organization: organization}
+			if config.MatchByDNSName {
+				rule.SAN = []string{regexPrefix + "^" + regexp.QuoteMeta(domain)}
+			} else {
+				rule.CommonName = []string{regexPrefix + "^" + regexp.QuoteMeta(domain) + "$"}
+			}
+			//an empty organization list matches nothing, as it used to
+			if len(organization) == 0 {
+				rule.Organization = []string{regexPrefix + `[^\s\S]`}
+			}
+			legacy = append(legacy, rule)
+		}
+	}
+	return legacy
+}
+
+//compilePatterns compile globs and "re:" prefixed regular expressions
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		expr := pattern
+		if strings.HasPrefix(pattern, regexPrefix) {
+			expr = strings.TrimPrefix(pattern, regexPrefix)
+		} else {
+			expr = regexp.QuoteMeta(pattern)
+			expr = strings.Replace(expr, `\*`, ".*", -1)
+			expr = strings.Replace(expr, `\?`, ".", -1)
+			expr = "^" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		checkErr(fmt.Sprintf("compile rule pattern %s error: ", pattern), err, Error)
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+//matchAny return the first value matched by any of patterns
+func matchAny(patterns []*regexp.Regexp, values ...string) (string, bool) {
+	for _, value := range values {
+		for _, re := range patterns {
+			if re.MatchString(value) {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+//match whether ip with leaf certificate cert satisfies the rule, it also
+//returns the SAN matched if the rule has a SAN condition
+func (rule *Rule) match(ip *IP, cert *x509.Certificate) (san string, ok bool) {
+	if len(rule.commonName) > 0 {
+		if _, ok = matchAny(rule.commonName, cert.Subject.CommonName); !ok {
+			return "", false
+		}
+	}
+	if len(rule.san) > 0 {
+		if san, ok = matchAny(rule.san, cert.DNSNames...); !ok {
+			return "", false
+		}
+	}
+	if len(rule.organization) > 0 {
+		if _, ok = matchAny(rule.organization, cert.Subject.Organization...); !ok {
+			return "", false
+		}
+	}
+	if len(rule.issuer) > 0 {
+		issuer := append([]string{cert.Issuer.CommonName}, cert.Issuer.Organization...)
+		if _, ok = matchAny(rule.issuer, issuer...); !ok {
+			return "", false
+		}
+	}
+	if len(rule.alpn) > 0 {
+		if _, ok = matchAny(rule.alpn, ip.Protocol); !ok {
+			return "", false
+		}
+	}
+	if len(rule.HTTPStatus) > 0 {
+		ok = false
+		for _, status := range rule.HTTPStatus {
+			if status == ip.HTTPStatus {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return "", false
+		}
+	}
+	if len(rule.httpServer) > 0 {
+		if _, ok = matchAny(rule.httpServer, ip.HTTPServer); !ok {
+			return "", false
+		}
+	}
+	return san, true
+}
+
+//classifyIP set the server name of ip to the class of the first matching
+//rule, it returns false if no rule matches
+func classifyIP(ip *IP, cert *x509.Certificate) bool {
+	for _, rule := range rules {
+		if san, ok := rule.match(ip, cert); ok {
+			ip.ServerName = rule.Class
+			if san != "" {
+				ip.CommonName = san
+			}
+			return true
+		}
+	}
+	return false
+}
+
+//formatClassCount format the number of ip of every class, like
+//"gws: 1, gvs: 2"
+func formatClassCount(count map[string]int) string {
+	var classes []string
+	for _, class := range ruleClasses {
+		classes = append(classes, class+": "+strconv.Itoa(count[class]))
+	}
+	return strings.Join(classes, ", ")
+}
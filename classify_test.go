@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestLegacyRules(t *testing.T) {
+	savedConfig, savedRules, savedClasses := config, rules, ruleClasses
+	defer func() {
+		config, rules, ruleClasses = savedConfig, savedRules, savedClasses
+	}()
+
+	cert := func(cn, org string, sans ...string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:  pkix.Name{CommonName: cn, Organization: []string{org}},
+			DNSNames: sans,
+		}
+	}
+	tests := []struct {
+		name      string
+		orgs      []string
+		byDNSName bool
+		cert      *x509.Certificate
+		class     string
+		cn        string
+	}{
+		{"gws by common name", []string{"Google Inc"}, false, cert("google.com", "Google Inc"), "gws", "google.com"},
+		{"gvs by common name", []string{"Google Inc"}, false, cert("*.googlevideo.com", "Google Inc"), "gvs", "*.googlevideo.com"},
+		{"common name is matched whole", []string{"Google Inc"}, false, cert("www.google.com", "Google Inc", "google.com"), "", ""},
+		{"other organization", []string{"Google Inc"}, false, cert("google.com", "Google LLC"), "", ""},
+		{"gws by dns name prefix", []string{"Google Inc"}, true, cert("*.google.com", "Google Inc", "example.org", "google.com.hk"), "gws", "google.com.hk"},
+		{"gvs by dns name", []string{"Google Inc"}, true, cert("*.google.com", "Google Inc", "*.googlevideo.com"), "gvs", "*.googlevideo.com"},
+		{"dns name is matched as prefix", []string{"Google Inc"}, true, cert("google.com", "Google Inc", "www.google.com"), "", ""},
+		{"common name is not used by dns name", []string{"Google Inc"}, true, cert("google.com", "Google Inc"), "", ""},
+		{"empty organization, by common name", nil, false, cert("google.com", ""), "", ""},
+		{"empty organization, by dns name", nil, true, cert("google.com", "", "google.com"), "", ""},
+	}
+	for _, tt := range tests {
+		config.Rules = nil
+		config.OrgNames = tt.orgs
+		config.GwsDomains = []string{"google.", "google.com"}
+		config.GvsDomains = []string{"*.googlevideo.com"}
+		config.MatchByDNSName = tt.byDNSName
+		rules, ruleClasses = nil, nil
+		initRules()
+
+		ip := IP{CommonName: tt.cert.Subject.CommonName}
+		ok := classifyIP(&ip, tt.cert)
+		if ok != (tt.class != "") || ip.ServerName != tt.class {
+			t.Errorf("%s: class %q (ok %v), want %q", tt.name, ip.ServerName, ok, tt.class)
+		}
+		if ok && ip.CommonName != tt.cn {
+			t.Errorf("%s: common name %q, want %q", tt.name, ip.CommonName, tt.cn)
+		}
+	}
+}
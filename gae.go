@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/proxy"
+)
+
+const gaeProberName = "gae"
+
+//GAECheck check whether ip serves App Engine by a domain fronted request
+type GAECheck struct {
+	Enabled   bool     `json:"enabled"`
+	Method    string   `json:"method"`
+	Host      string   `json:"host"`
+	Path      string   `json:"path"`
+	Status    []int    `json:"status"`
+	Body      []string `json:"body"`
+	OnlyGAEIP bool     `json:"only_gae_ip"`
+}
+
+//GAEResult result of the App Engine request to an ip
+type GAEResult struct {
+	Checked bool
+	OK      bool
+	Status  int
+}
+
+//gaeProber record whether ip serves App Engine, it never rejects an ip
+type gaeProber struct{}
+
+func init() {
+	registerProber(gaeProber{})
+}
+
+//Name of gaeProber
+func (gaeProber) Name() string {
+	return gaeProberName
+}
+
+//Probe send the App Engine request over a new connection, p.Conn may be
+//taken by the http prober
+func (gaeProber) Probe(ctx context.Context, p *Probe) (int, error) {
+	p.IP.GAE = checkGAE(ctx, p.Config, p.Dialer, p.IP.Address, p.SNI)
+	return okIP, nil
+}
+
+//checkGAE handshake with ip using sni and send the configured request with
+//the appspot Host, the response must match the status and body markers
+func checkGAE(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip, sni string) GAEResult {
+	result := GAEResult{Checked: true}
+	conn, _, _, err := handshakeIP(ctx, cfg, dialer, ip, sni)
+	if err != nil {
+		checkErr(fmt.Sprintf("%s gae: ", ip), err, Debug)
+		return result
+	}
+	defer conn.Close()
+
+	method := cfg.GAECheck.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	status, _, body, err := sendHTTPRequest(ctx, conn, method, cfg.GAECheck.Host, cfg.GAECheck.Path)
+	if err != nil {
+		checkErr(fmt.Sprintf("%s gae: ", ip), err, Debug)
+		return result
+	}
+	result.Status = status
+	if len(cfg.GAECheck.Status) > 0 {
+		matched := false
+		for _, s := range cfg.GAECheck.Status {
+			if s == status {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return result
+		}
+	}
+	for _, marker := range cfg.GAECheck.Body {
+		if !bytes.Contains(body, []byte(marker)) {
+			return result
+		}
+	}
+	result.OK = true
+	return result
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCheckGAE(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "gae.example.com" || r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("served by App Engine"))
+	}))
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	saved := sniTLSConfigs
+	sniTLSConfigs = map[string]*tls.Config{sniNone: {InsecureSkipVerify: true}}
+	defer func() {
+		sniTLSConfigs = saved
+	}()
+
+	tests := []struct {
+		name   string
+		check  GAECheck
+		ok     bool
+		status int
+	}{
+		{"status and body match", GAECheck{Host: "gae.example.com", Path: "/ok", Status: []int{200}, Body: []string{"App Engine"}}, true, 200},
+		{"no status and body configured", GAECheck{Host: "gae.example.com", Path: "/ok"}, true, 200},
+		{"status mismatch", GAECheck{Host: "gae.example.com", Path: "/missing", Status: []int{200}}, false, 404},
+		{"body marker missing", GAECheck{Host: "gae.example.com", Path: "/ok", Body: []string{"App Engine", "appspot"}}, false, 200},
+		{"wrong host", GAECheck{Host: "www.example.com", Path: "/ok", Status: []int{200, 204}}, false, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{HandshakeTimeout: 5000, Port: port, GAECheck: tt.check}
+			result := checkGAE(context.Background(), cfg, &net.Dialer{}, host, sniNone)
+			if !result.Checked || result.OK != tt.ok || result.Status != tt.status {
+				t.Errorf("checkGAE() = %+v, want ok %v status %d", result, tt.ok, tt.status)
+			}
+		})
+	}
+}
+
+func TestCheckGAEUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cfg := &Config{HandshakeTimeout: 5000, Port: port, GAECheck: GAECheck{Host: "gae.example.com"}}
+	result := checkGAE(context.Background(), cfg, &net.Dialer{}, "127.0.0.1", sniNone)
+	if !result.Checked || result.OK || result.Status != 0 {
+		t.Errorf("checkGAE() = %+v, want a failed check", result)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/johnsonz/go-checkiptools
+
+go 1.25.0
+
+require (
+	github.com/golang/glog v1.2.5
+	github.com/quic-go/quic-go v0.59.0
+	golang.org/x/net v0.57.0
+	golang.org/x/sys v0.47.0
+)
+
+require golang.org/x/crypto v0.54.0 // indirect
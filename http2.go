@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+//http2 client connection preface, see RFC 7540 section 3.5
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+//http2 frame
+const (
+	http2FrameHeaderLen    = 9
+	http2FrameSettings     = 0x4
+	http2FlagSettingsAck   = 0x1
+	http2MaxFramesToSettle = 10
+)
+
+const http2ProberName = "http2"
+
+//http2Prober require h2 to be negotiated, and optionally a completed
+//preface and SETTINGS exchange
+type http2Prober struct{}
+
+func init() {
+	registerProber(http2Prober{})
+}
+
+//Name of http2Prober
+func (http2Prober) Name() string {
+	return http2ProberName
+}
+
+//Probe check the h2 support of p.Conn
+func (http2Prober) Probe(ctx context.Context, p *Probe) (int, error) {
+	if p.IP.Protocol != "h2" {
+		return noIP, fmt.Errorf("negotiated protocol is %s", p.IP.Protocol)
+	}
+	//an http check over h2 does the preface and SETTINGS exchange itself
+	if p.Config.HTTP2Preface && !proberEnabled(httpProberName) {
+		if err := checkHTTP2Settings(ctx, p.Conn); err != nil {
+			return noIP, err
+		}
+	}
+	return okIP, nil
+}
+
+//checkHTTP2Settings send the client preface with an empty SETTINGS frame,
+//then wait for the server SETTINGS frame and the ack of ours.
+func checkHTTP2Settings(ctx context.Context, conn net.Conn) error {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	settings := []byte{0, 0, 0, http2FrameSettings, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(append([]byte(http2ClientPreface), settings...)); err != nil {
+		return err
+	}
+
+	serverSettings, settingsAcked := false, false
+	for i := 0; i < http2MaxFramesToSettle && !(serverSettings && settingsAcked); i++ {
+		header := make([]byte, http2FrameHeaderLen)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+		length := int64(header[0])<<16 | int64(header[1])<<8 | int64(header[2])
+		frameType, flags := header[3], header[4]
+		if _, err := io.CopyN(ioutil.Discard, conn, length); err != nil {
+			return err
+		}
+		if !serverSettings && frameType != http2FrameSettings {
+			return fmt.Errorf("first frame type is %d, not SETTINGS", frameType)
+		}
+		if frameType != http2FrameSettings {
+			continue
+		}
+		if flags&http2FlagSettingsAck != 0 {
+			settingsAcked = true
+			continue
+		}
+		serverSettings = true
+		ack := []byte{0, 0, 0, http2FrameSettings, http2FlagSettingsAck, 0, 0, 0, 0}
+		if _, err := conn.Write(ack); err != nil {
+			return err
+		}
+	}
+	if !serverSettings || !settingsAcked {
+		return errors.New("http2 SETTINGS exchange not completed")
+	}
+	return nil
+}
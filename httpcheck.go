@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+const httpProberName = "http"
+
+//httpProber verify the response of a request sent after the handshake
+type httpProber struct{}
+
+func init() {
+	registerProber(httpProber{})
+}
+
+//Name of httpProber
+func (httpProber) Name() string {
+	return httpProberName
+}
+
+//Probe send the configured request over p.Conn and match the response
+func (httpProber) Probe(ctx context.Context, p *Probe) (int, error) {
+	var err error
+	p.IP.HTTPStatus, p.IP.HTTPServer, err = checkHTTP(ctx, p.Config, p.Conn, p.SNI)
+	if err == nil {
+		err = matchHTTPResponse(p.Config, p.IP.HTTPStatus, p.IP.HTTPServer)
+	}
+	if err != nil {
+		return noIP, err
+	}
+	return okIP, nil
+}
+
+//checkHTTP send the configured request over the established tls connection,
+//the Host is the SNI of the connection if none is configured. It returns the
+//response status code and Server header.
+func checkHTTP(ctx context.Context, cfg *Config, conn *tls.Conn, sni string) (status int, server string, err error) {
+	method := cfg.HTTPCheck.Method
+	if method == "" {
+		method = http.MethodHead
+	}
+	host := cfg.HTTPCheck.Host
+	if host == "" {
+		host = sni
+	}
+	status, header, _, err := sendHTTPRequest(ctx, conn, method, host, cfg.HTTPCheck.Path)
+	if err != nil {
+		return 0, "", err
+	}
+	return status, header.Get("Server"), nil
+}
+
+//sendHTTPRequest send a request for host and path over the established tls
+//connection, h2 is used if it was negotiated. Redirects are not followed and
+//at most 64KB of the body is read.
+func sendHTTPRequest(ctx context.Context, conn *tls.Conn, method, host, path string) (status int, header http.Header, body []byte, err error) {
+	transport := &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return conn, nil
+		},
+		ForceAttemptHTTP2: true,
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "https://"+host+path, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+//matchHTTPResponse check status and server against the configured ones,
+//an empty list matches anything.
+func matchHTTPResponse(cfg *Config, status int, server string) error {
+	if len(cfg.HTTPCheck.Status) > 0 {
+		matched := false
+		for _, s := range cfg.HTTPCheck.Status {
+			if s == status {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("unexpected http status %d", status)
+		}
+	}
+	if len(cfg.HTTPCheck.Server) > 0 {
+		matched := false
+		for _, s := range cfg.HTTPCheck.Server {
+			if s == server {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("unexpected server header %q", server)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPProber(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "www.google.com" {
+			w.WriteHeader(http.StatusMisdirectedRequest)
+			return
+		}
+		w.Header().Set("Server", "gws")
+		if r.URL.Path == "/generate_204" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		check  HTTPCheck
+		sni    string
+		ok     bool
+		status int
+	}{
+		{"status and server match", HTTPCheck{Host: "www.google.com", Path: "/generate_204", Status: []int{204}, Server: []string{"gws"}}, sniNone, true, 204},
+		{"nothing to match", HTTPCheck{Host: "www.google.com", Path: "/generate_204"}, sniNone, true, 204},
+		{"status mismatch", HTTPCheck{Host: "www.google.com", Path: "/missing", Status: []int{204}}, sniNone, false, 404},
+		{"server mismatch", HTTPCheck{Host: "www.google.com", Path: "/generate_204", Server: []string{"gvs 1.0"}}, sniNone, false, 204},
+		{"host falls back to the sni", HTTPCheck{Path: "/generate_204", Status: []int{204}}, "www.google.com", true, 204},
+		{"wrong host", HTTPCheck{Host: "www.example.com", Path: "/generate_204", Status: []int{204}}, sniNone, false, 421},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &Probe{Config: &Config{HTTPCheck: tt.check}, Conn: conn, SNI: tt.sni}
+			defer p.Close()
+			status, err := httpProber{}.Probe(context.Background(), p)
+			if (status == okIP) != tt.ok || p.IP.HTTPStatus != tt.status {
+				t.Errorf("Probe() = %d, %v, http status %d, want ok %v http status %d", status, err, p.IP.HTTPStatus, tt.ok, tt.status)
+			}
+			if tt.ok && p.IP.HTTPServer != "gws" {
+				t.Errorf("server = %q, want gws", p.IP.HTTPServer)
+			}
+		})
+	}
+}
@@ -2,22 +2,54 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //The IP struct
 type IP struct {
-	Address     string
-	CountryName string
-	CommonName  string
-	OrgName     string
-	ServerName  string
-	Delay       int
-	Bandwidth   int
+	Address      string
+	CountryName  string
+	CommonName   string
+	OrgName      string
+	ServerName   string
+	Protocol     string
+	HTTPStatus   int
+	HTTPServer   string
+	Delay        int //tls handshake time
+	ConnectDelay int
+	TotalDelay   int
+	Bandwidth    int
+	SNIResults   []SNIResult
+	ProxySNI     []SNIResult //results of the unrelated SNIs of sni_proxy
+	Stats        DelayStats
+	QUIC         QUICResult
+	GAE          GAEResult
+	Resume       ResumeResult
+	TCPInfo      TCPInfo //read after the handshake, only on linux
+	TLS          TLSDetails
+	Via          string //proxy chain the delays were measured through
+	Uplink       string
+	FailureClass string
+	Retries      int
+	//only set if verify_cert is enabled
+	CertVerified    bool
+	CertVerifyError string
+}
+
+//SNIResult handshake result of ip with one SNI
+type SNIResult struct {
+	ServerName string
+	OK         bool
+	CommonName string
+	Delay      int
 }
 
 // The status of type IP
@@ -25,6 +57,7 @@ const (
 	okIP = iota
 	noIP
 	errIP
+	interceptedIP
 )
 
 //IPs []IP
@@ -40,14 +73,92 @@ func (ips IPs) Swap(i, j int) {
 	ips[i], ips[j] = ips[j], ips[i]
 }
 
-//ByDelay sort by delay
+//delay metric used for sorting and the delay cutoff
+const (
+	delayHandshake = "handshake"
+	delayConnect   = "connect"
+	delayTotal     = "total"
+	delayResumed   = "resumed"
+	delayRTT       = "rtt"
+)
+
+//initDelayMetric check the configured delay metric
+func initDelayMetric() {
+	switch config.DelayMetric {
+	case delayHandshake, delayConnect, delayTotal, delayResumed:
+	case delayRTT:
+		if !tcpInfoSupported {
+			checkErr("parse config file error: ", fmt.Errorf("delay_metric %q is only supported on linux", delayRTT), Error)
+		}
+		if len(config.Proxies) > 0 {
+			checkErr("parse config file error: ", fmt.Errorf("delay_metric %q can not be used with proxy, it would measure the proxy", delayRTT), Error)
+		}
+	case "":
+		config.DelayMetric = delayHandshake
+	default:
+		checkErr("parse config file error: ", fmt.Errorf("unknown delay_metric %q", config.DelayMetric), Error)
+	}
+	switch config.DelayStat {
+	case statFirst, statMin, statAvg, statMedian, statMax, statStdDev:
+	case "":
+		config.DelayStat = statFirst
+	default:
+		checkErr("parse config file error: ", fmt.Errorf("unknown delay_stat %q", config.DelayStat), Error)
+	}
+}
+
+//metricDelay return the delay chosen by delay_metric
+func (ip IP) metricDelay(cfg *Config) int {
+	switch cfg.DelayMetric {
+	case delayConnect:
+		return ip.ConnectDelay
+	case delayTotal:
+		return ip.TotalDelay
+	case delayResumed:
+		//an ip which does not resume always pays the full handshake
+		if ip.Resume.OK {
+			return ip.Resume.Delay
+		}
+	case delayRTT:
+		//connections through a proxy have no tcp info
+		if ip.TCPInfo.Checked {
+			return ip.TCPInfo.rttDelay()
+		}
+	}
+	return ip.Delay
+}
+
+//sortDelay return the delay statistic chosen by delay_stat, it falls back
+//to metricDelay if the ip has not been sampled. Samples are full handshakes,
+//so they are not used for the resumed delay.
+func (ip IP) sortDelay(cfg *Config) int {
+	if ip.Stats.Samples < 2 || cfg.DelayMetric == delayResumed {
+		return ip.metricDelay(cfg)
+	}
+	switch cfg.DelayStat {
+	case statMin:
+		return ip.Stats.Min
+	case statAvg:
+		return ip.Stats.Avg
+	case statMedian:
+		return ip.Stats.Median
+	case statMax:
+		return ip.Stats.Max
+	case statStdDev:
+		return ip.Stats.StdDev
+	}
+	return ip.metricDelay(cfg)
+}
+
+//ByDelay sort by delay, with the metric and statistic of cfg
 type ByDelay struct {
 	IPs
+	cfg *Config
 }
 
 //Less return false if the first value less than the second one
 func (s ByDelay) Less(i, j int) bool {
-	return s.IPs[i].Delay < s.IPs[j].Delay
+	return s.IPs[i].sortDelay(s.cfg) < s.IPs[j].sortDelay(s.cfg)
 }
 
 //ByBandwidth sort by bandwidth
@@ -71,23 +182,26 @@ func getLastOkIP() []IP {
 		lines := strings.Split(string(bytes), "\n")
 		for _, line := range lines {
 			ipInfo := strings.Split(line, " ")
-			if len(ipInfo) == 5 || len(ipInfo) == 6 {
+			if len(ipInfo) >= 5 {
 				delay, err := strconv.Atoi(ipInfo[1][:len(ipInfo[1])-2])
 				checkErr("delay conversion failed: ", err, Warning)
-				bandwidth := 0
-				if len(ipInfo) == 6 {
-					bandwidth, err = strconv.Atoi(ipInfo[5][:len(ipInfo[5])-4])
-					checkErr("bandwidth conversion failed: ", err, Warning)
-				}
 				checkedip = IP{
 					Address:     ipInfo[0],
 					Delay:       delay,
 					CommonName:  ipInfo[2],
 					ServerName:  ipInfo[3],
 					CountryName: ipInfo[4],
-					Bandwidth:   bandwidth,
+					Protocol:    "-",
 				}
-				m[ipInfo[0]] = checkedip
+				for _, info := range ipInfo[5:] {
+					if strings.HasSuffix(info, "KB/s") {
+						checkedip.Bandwidth, err = strconv.Atoi(info[:len(info)-4])
+						checkErr("bandwidth conversion failed: ", err, Warning)
+					} else if n := strings.Index(info, "="); n > 0 {
+						parseIPExtra(&checkedip, info[:n], info[n+1:])
+					}
+				}
+				m[checkedip.Address+" "+checkedip.Uplink] = checkedip
 			}
 		}
 	}
@@ -97,6 +211,232 @@ func getLastOkIP() []IP {
 	return ips
 }
 
+//formatIPExtra format the optional key=value fields of ip, which follow
+//the fixed fields in ip_tmp*.txt
+func formatIPExtra(ip IP) string {
+	var buf bytes.Buffer
+	if ip.Protocol != "" && ip.Protocol != "-" {
+		fmt.Fprintf(&buf, " proto=%s", ip.Protocol)
+	}
+	if ip.ConnectDelay > 0 || ip.TotalDelay > 0 {
+		fmt.Fprintf(&buf, " connect=%dms total=%dms", ip.ConnectDelay, ip.TotalDelay)
+	}
+	if ip.Uplink != "" {
+		fmt.Fprintf(&buf, " uplink=%s", escapeIPField(ip.Uplink))
+	}
+	if ip.Via != "" {
+		fmt.Fprintf(&buf, " via=%s", escapeIPField(ip.Via))
+	}
+	if ip.Stats.Samples > 1 {
+		fmt.Fprintf(&buf, " stats=%d:%d:%d:%d:%d:%d loss=%.2f", ip.Stats.Samples, ip.Stats.Min, ip.Stats.Avg,
+			ip.Stats.Median, ip.Stats.Max, ip.Stats.StdDev, ip.Stats.Loss)
+	}
+	if ip.HTTPStatus > 0 {
+		fmt.Fprintf(&buf, " status=%d server=%s", ip.HTTPStatus, escapeIPField(ip.HTTPServer))
+	}
+	if len(ip.SNIResults) > 1 || len(ip.SNIResults) == 1 && ip.SNIResults[0].ServerName != sniNone {
+		fmt.Fprintf(&buf, " sni=%s", formatSNIResults(ip.SNIResults))
+	}
+	if len(ip.ProxySNI) > 0 {
+		fmt.Fprintf(&buf, " proxysni=%s", formatSNIResults(ip.ProxySNI))
+	}
+	if ip.QUIC.Checked {
+		if ip.QUIC.OK {
+			fmt.Fprintf(&buf, " quic=%dms:%s:%s", ip.QUIC.Delay, escapeIPField(ip.QUIC.Protocol), escapeIPField(ip.QUIC.CommonName))
+		} else {
+			buf.WriteString(" quic=err")
+		}
+	}
+	if ip.GAE.Checked {
+		if ip.GAE.OK {
+			fmt.Fprintf(&buf, " gae=ok:%d", ip.GAE.Status)
+		} else {
+			fmt.Fprintf(&buf, " gae=err:%d", ip.GAE.Status)
+		}
+	}
+	if ip.QUIC.Ticket {
+		fmt.Fprintf(&buf, " earlydata=%t", ip.QUIC.EarlyData)
+	}
+	if ip.TCPInfo.Checked {
+		fmt.Fprintf(&buf, " tcpinfo=%dus:%dus:%d", ip.TCPInfo.RTT, ip.TCPInfo.RTTVar, ip.TCPInfo.Retransmits)
+	}
+	if ip.Resume.Checked {
+		if ip.Resume.OK {
+			fmt.Fprintf(&buf, " resume=%dms", ip.Resume.Delay)
+		} else {
+			buf.WriteString(" resume=no")
+		}
+	}
+	if ip.TLS.Fingerprint != "" {
+		fmt.Fprintf(&buf, " tls=%s cipher=%s group=%s fp=%s spki=%s issuer=%s notbefore=%s notafter=%s sans=%d ocsp=%t sct=%t",
+			escapeIPField(ip.TLS.Version), ip.TLS.CipherSuite, escapeIPField(ip.TLS.Group), ip.TLS.Fingerprint, ip.TLS.SPKIHash,
+			escapeIPField(ip.TLS.Issuer), ip.TLS.NotBefore.Format(time.RFC3339), ip.TLS.NotAfter.Format(time.RFC3339),
+			ip.TLS.SANCount, ip.TLS.OCSPStapled, ip.TLS.SCT)
+	}
+	if ip.FailureClass != "" {
+		fmt.Fprintf(&buf, " fail=%s", ip.FailureClass)
+	}
+	if ip.Retries > 0 {
+		fmt.Fprintf(&buf, " retries=%d", ip.Retries)
+	}
+	if ip.CertVerified {
+		buf.WriteString(" verify=ok")
+	} else if ip.CertVerifyError != "" {
+		fmt.Fprintf(&buf, " verify=%s", escapeIPField(ip.CertVerifyError))
+	}
+	return buf.String()
+}
+
+//parseIPExtra parse one key=value field written by formatIPExtra
+func parseIPExtra(ip *IP, key, value string) {
+	switch key {
+	case "proto":
+		ip.Protocol = value
+	case "status":
+		status, err := strconv.Atoi(value)
+		checkErr("http status conversion failed: ", err, Warning)
+		ip.HTTPStatus = status
+	case "server":
+		server, err := url.PathUnescape(value)
+		checkErr("http server unescape failed: ", err, Warning)
+		ip.HTTPServer = server
+	case "connect":
+		delay, err := strconv.Atoi(strings.TrimSuffix(value, "ms"))
+		checkErr("connect delay conversion failed: ", err, Warning)
+		ip.ConnectDelay = delay
+	case "total":
+		delay, err := strconv.Atoi(strings.TrimSuffix(value, "ms"))
+		checkErr("total delay conversion failed: ", err, Warning)
+		ip.TotalDelay = delay
+	case "stats":
+		_, err := fmt.Sscanf(value, "%d:%d:%d:%d:%d:%d", &ip.Stats.Samples, &ip.Stats.Min, &ip.Stats.Avg,
+			&ip.Stats.Median, &ip.Stats.Max, &ip.Stats.StdDev)
+		checkErr("delay stats conversion failed: ", err, Warning)
+	case "loss":
+		loss, err := strconv.ParseFloat(value, 64)
+		checkErr("loss conversion failed: ", err, Warning)
+		ip.Stats.Loss = loss
+	case "tcpinfo":
+		if fields := strings.Split(value, ":"); len(fields) == 3 {
+			ip.TCPInfo.Checked = true
+			ip.TCPInfo.RTT, _ = strconv.Atoi(strings.TrimSuffix(fields[0], "us"))
+			ip.TCPInfo.RTTVar, _ = strconv.Atoi(strings.TrimSuffix(fields[1], "us"))
+			ip.TCPInfo.Retransmits, _ = strconv.Atoi(fields[2])
+		}
+	case "earlydata":
+		ip.QUIC.Ticket = true
+		ip.QUIC.EarlyData = value == "true"
+	case "resume":
+		ip.Resume = ResumeResult{Checked: true}
+		if delay, err := strconv.Atoi(strings.TrimSuffix(value, "ms")); err == nil {
+			ip.Resume.OK = true
+			ip.Resume.Delay = delay
+		}
+	case "gae":
+		fields := strings.SplitN(value, ":", 2)
+		ip.GAE = GAEResult{Checked: true, OK: fields[0] == "ok"}
+		if len(fields) == 2 {
+			ip.GAE.Status, _ = strconv.Atoi(fields[1])
+		}
+	case "quic":
+		ip.QUIC = QUICResult{Checked: true}
+		if fields := strings.SplitN(value, ":", 3); len(fields) == 3 {
+			ip.QUIC.OK = true
+			ip.QUIC.Delay, _ = strconv.Atoi(strings.TrimSuffix(fields[0], "ms"))
+			ip.QUIC.Protocol, _ = url.PathUnescape(fields[1])
+			ip.QUIC.CommonName, _ = url.PathUnescape(fields[2])
+		}
+	case "uplink":
+		ip.Uplink, _ = url.PathUnescape(value)
+	case "via":
+		ip.Via, _ = url.PathUnescape(value)
+	case "tls":
+		ip.TLS.Version, _ = url.PathUnescape(value)
+	case "cipher":
+		ip.TLS.CipherSuite = value
+	case "group":
+		ip.TLS.Group, _ = url.PathUnescape(value)
+	case "fp":
+		ip.TLS.Fingerprint = value
+	case "spki":
+		ip.TLS.SPKIHash = value
+	case "issuer":
+		ip.TLS.Issuer, _ = url.PathUnescape(value)
+	case "notbefore":
+		t, err := time.Parse(time.RFC3339, value)
+		checkErr("notbefore conversion failed: ", err, Warning)
+		ip.TLS.NotBefore = t
+	case "notafter":
+		t, err := time.Parse(time.RFC3339, value)
+		checkErr("notafter conversion failed: ", err, Warning)
+		ip.TLS.NotAfter = t
+	case "sans":
+		count, err := strconv.Atoi(value)
+		checkErr("san count conversion failed: ", err, Warning)
+		ip.TLS.SANCount = count
+	case "ocsp":
+		ip.TLS.OCSPStapled = value == "true"
+	case "sct":
+		ip.TLS.SCT = value == "true"
+	case "fail":
+		ip.FailureClass = value
+	case "retries":
+		retries, err := strconv.Atoi(value)
+		checkErr("retries conversion failed: ", err, Warning)
+		ip.Retries = retries
+	case "verify":
+		if value == "ok" {
+			ip.CertVerified = true
+		} else {
+			ip.CertVerifyError, _ = url.PathUnescape(value)
+		}
+	case "sni":
+		ip.SNIResults = parseSNIResults(value)
+	case "proxysni":
+		ip.ProxySNI = parseSNIResults(value)
+	}
+}
+
+//formatSNIResults format results as comma separated name:Nms:cn, or
+//name:err for a failed handshake
+func formatSNIResults(results []SNIResult) string {
+	fields := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.OK {
+			fields = append(fields, fmt.Sprintf("%s:%dms:%s", r.ServerName, r.Delay, escapeIPField(r.CommonName)))
+		} else {
+			fields = append(fields, r.ServerName+":err")
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+//parseSNIResults parse the results written by formatSNIResults
+func parseSNIResults(value string) []SNIResult {
+	var results []SNIResult
+	for _, result := range strings.Split(value, ",") {
+		fields := strings.SplitN(result, ":", 3)
+		r := SNIResult{ServerName: fields[0]}
+		if len(fields) == 3 {
+			r.OK = true
+			r.Delay, _ = strconv.Atoi(strings.TrimSuffix(fields[1], "ms"))
+			r.CommonName, _ = url.PathUnescape(fields[2])
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+//escapeIPField escape the characters that would break the space separated
+//line of ip_tmp*.txt
+func escapeIPField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	s = strings.Replace(s, "%", "%25", -1)
+	return strings.Replace(s, " ", "%20", -1)
+}
+
 //get all google ip range from googleip.txt file
 func getGoogleIPRange() []string {
 	var ipRanges []string
@@ -123,74 +463,81 @@ func getGoogleIPRange() []string {
   4. xxx.xxx.xxx.xxx-xxx.
   5. xxx.-xxx.
   6. xxx.xxx.
+  7. xxxx:xxxx::xxxx
+  8. xxxx:xxxx::/xx
+  9. xxxx:xxxx::xxxx-xxxx:xxxx::xxxx
+  IPv6 ranges larger than ipv6.max_per_prefix are sampled.
 */
 func parseGoogleIPRange(ipRange string) []string {
+	if strings.Contains(ipRange, ":") {
+		return parseGoogleIPv6Range(ipRange)
+	}
 	var ips []string
+	first, last := parseIPv4Range(ipRange)
+	for n := first; n <= last; n++ {
+		ips = append(ips, uint2IPv4(n).String())
+	}
+
+	return ips
+}
+
+//parseIPv4Range return the first and last address of an ipv4 range as
+//numbers, last is less than first if the range is empty
+func parseIPv4Range(ipRange string) (first, last int64) {
 	if strings.Contains(ipRange, "/") {
 		//CIDR: https://zh.wikipedia.org/wiki/%E6%97%A0%E7%B1%BB%E5%88%AB%E5%9F%9F%E9%97%B4%E8%B7%AF%E7%94%B1
-		ip, ipNet, err := net.ParseCIDR(ipRange)
+		_, ipNet, err := net.ParseCIDR(ipRange)
 		checkErr(fmt.Sprintf("parse CIDR %s error: ", ipRange), err, Error)
 
-		for iptmp := ip.Mask(ipNet.Mask); ipNet.Contains(iptmp); inc(iptmp) {
-			ips = append(ips, iptmp.String())
-		}
+		ones, bits := ipNet.Mask.Size()
+		first = ipv42Uint(ipRange, ipNet.IP)
+		last = first + 1<<uint(bits-ones) - 1
 		// remove network address and broadcast address
-		return ips[1 : len(ips)-1]
-	} else if strings.Contains(ipRange, "-") {
-		startIP := ipRange[:strings.Index(ipRange, "-")]
-		endIP := ipRange[strings.Index(ipRange, "-")+1:]
-		if strings.HasSuffix(startIP, ".") {
-			switch strings.Count(startIP, ".") {
-			case 1:
-				startIP += "0.0.0"
-			case 2:
-				startIP += "0.0"
-			case 3:
-				startIP += "0"
-			}
+		return first + 1, last - 1
+	}
+	startIP, endIP := ipRange, ipRange
+	if strings.Contains(ipRange, "-") {
+		startIP = ipRange[:strings.Index(ipRange, "-")]
+		endIP = ipRange[strings.Index(ipRange, "-")+1:]
+	}
+	if strings.HasSuffix(startIP, ".") {
+		switch strings.Count(startIP, ".") {
+		case 1:
+			startIP += "0.0.0"
+		case 2:
+			startIP += "0.0"
+		case 3:
+			startIP += "0"
 		}
-		if strings.HasSuffix(endIP, ".") {
-			switch strings.Count(endIP, ".") {
-			case 1:
-				endIP += "255.255.255"
-			case 2:
-				endIP += "255.255"
-			case 3:
-				endIP += "255"
-			}
+	}
+	if strings.HasSuffix(endIP, ".") {
+		switch strings.Count(endIP, ".") {
+		case 1:
+			endIP += "255.255.255"
+		case 2:
+			endIP += "255.255"
+		case 3:
+			endIP += "255"
 		}
-		sIP := net.ParseIP(startIP)
-		eIP := net.ParseIP(endIP)
-
-		for ip := sIP; bytes.Compare(ip, eIP) <= 0; inc(ip) {
-			ips = append(ips, ip.String())
-		}
-	} else {
-		if strings.HasSuffix(ipRange, ".") {
-			startIP, endIP := ipRange, ipRange
-			switch strings.Count(ipRange, ".") {
-			case 1:
-				startIP += "0.0.0"
-				endIP += "255.255.255"
-			case 2:
-				startIP += "0.0"
-				endIP += "255.255"
-			case 3:
-				startIP += "0"
-				endIP += "255"
-			}
-			sIP := net.ParseIP(startIP)
-			eIP := net.ParseIP(endIP)
+	}
 
-			for ip := sIP; bytes.Compare(ip, eIP) <= 0; inc(ip) {
-				ips = append(ips, ip.String())
-			}
-		} else {
-			ips = append(ips, ipRange)
-		}
+	return ipv42Uint(ipRange, net.ParseIP(startIP)), ipv42Uint(ipRange, net.ParseIP(endIP))
+}
+
+//ipv42Uint convert an ipv4 address of ipRange to a number
+func ipv42Uint(ipRange string, ip net.IP) int64 {
+	ip = ip.To4()
+	if ip == nil {
+		checkErr(fmt.Sprintf("parse ip range %s error: ", ipRange), fmt.Errorf("invalid ip"), Error)
 	}
+	return int64(binary.BigEndian.Uint32(ip))
+}
 
-	return ips
+//uint2IPv4 convert a number to an ipv4 address
+func uint2IPv4(n int64) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, uint32(n))
+	return ip
 }
 
 //get all google ip
@@ -217,23 +564,18 @@ func getUniqueGoogleIP() map[string]string {
 	return ips
 }
 
-//get google ip one by one
-func getGoogleIPQueue() {
-	ipRanges := getGoogleIPRange()
-	ipRanges = convertMap2Array(convertArray2Map(ipRanges))
-	for _, ipRange := range ipRanges {
-		parsedips := parseGoogleIPRange(ipRange)
-		for _, ip := range parsedips {
-			totalips <- ip
+//get google ip one by one in scan order, starting at position from
+func getGoogleIPQueue(ctx context.Context, from scanPos) {
+	order := newScanOrder(sortedGoogleIPRange())
+	for i := from.Index; i < order.total; i++ {
+		if resumedDone[i] {
+			continue
 		}
-	}
-}
-
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+		ip := queuedIP{Address: order.ip(i), seq: tracker.emit(scanPos{Index: i})}
+		select {
+		case totalips <- ip:
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//extraIPs ips which set every field written by formatIPExtra
+var extraIPs = []IP{
+	{
+		Protocol:     "h2",
+		HTTPStatus:   204,
+		HTTPServer:   "gws 1.0",
+		ConnectDelay: 12,
+		TotalDelay:   80,
+		SNIResults: []SNIResult{
+			{ServerName: sniNone, OK: true, CommonName: "*.google.com", Delay: 68},
+			{ServerName: "www.google.com", OK: false},
+		},
+		ProxySNI: []SNIResult{
+			{ServerName: "example.org", OK: true, CommonName: "example.org", Delay: 70},
+		},
+		Stats:   DelayStats{Samples: 3, Min: 60, Avg: 70, Median: 68, Max: 82, StdDev: 9, Loss: 0.25},
+		QUIC:    QUICResult{Checked: true, OK: true, Delay: 40, Protocol: "h3", CommonName: "*.google.com", Ticket: true, EarlyData: true},
+		GAE:     GAEResult{Checked: true, OK: true, Status: 200},
+		Resume:  ResumeResult{Checked: true, OK: true, Delay: 30},
+		TCPInfo: TCPInfo{Checked: true, RTT: 11500, RTTVar: 800, Retransmits: 1},
+		TLS: TLSDetails{
+			Version:     "TLS 1.3",
+			CipherSuite: "TLS_AES_128_GCM_SHA256",
+			Group:       "X25519",
+			Fingerprint: "468174fd18ae990a",
+			SPKIHash:    "OJ+e3lINvDPSrrxIkkatieIh0ewV9pPDSMWLCCGTZ6o=",
+			Issuer:      "WR2 100%",
+			NotBefore:   time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC),
+			NotAfter:    time.Date(2026, 11, 24, 8, 0, 0, 0, time.UTC),
+			SANCount:    132,
+			OCSPStapled: true,
+			SCT:         true,
+		},
+		Via:          "socks5://127.0.0.1:1080",
+		Uplink:       "home wifi",
+		Retries:      2,
+		CertVerified: true,
+	},
+	{
+		Protocol:        "http/1.1",
+		QUIC:            QUICResult{Checked: true, Ticket: true},
+		GAE:             GAEResult{Checked: true, Status: 404},
+		Resume:          ResumeResult{Checked: true},
+		FailureClass:    failCertMismatch,
+		CertVerifyError: "x509: certificate is valid for a.example, not b.example",
+	},
+	{},
+}
+
+func TestIPExtraRoundTrip(t *testing.T) {
+	for i, ip := range extraIPs {
+		extra := formatIPExtra(ip)
+		var parsed IP
+		for _, field := range strings.Fields(extra) {
+			n := strings.Index(field, "=")
+			if n <= 0 {
+				t.Fatalf("ip %d: field %q is not key=value", i, field)
+			}
+			parseIPExtra(&parsed, field[:n], field[n+1:])
+		}
+		if !reflect.DeepEqual(parsed, ip) {
+			t.Errorf("ip %d: %q\nparsed %+v\nwant   %+v", i, extra, parsed, ip)
+		}
+	}
+}
+
+func TestIPFileRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile(tmpOkIPFileName, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ip := extraIPs[0]
+	ip.Address = "216.58.200.4"
+	ip.Delay = 68
+	ip.CommonName = "*.google.com"
+	ip.ServerName = "gws"
+	ip.CountryName = "US"
+	appendIP2File(ip, tmpOkIPFileName)
+
+	ips := getLastOkIP()
+	if len(ips) != 1 {
+		t.Fatalf("read %d ips, want 1", len(ips))
+	}
+	if !reflect.DeepEqual(ips[0], ip) {
+		t.Errorf("read %+v\nwant %+v", ips[0], ip)
+	}
+}
+
+func TestSNIResultsRoundTrip(t *testing.T) {
+	results := []SNIResult{
+		{ServerName: sniNone, OK: true, CommonName: "-", Delay: 1},
+		{ServerName: "a.example", OK: false},
+		{ServerName: "b.example", OK: true, CommonName: "cn:with:colons", Delay: 300},
+	}
+	if got := parseSNIResults(formatSNIResults(results)); !reflect.DeepEqual(got, results) {
+		t.Errorf("parseSNIResults() = %+v, want %+v", got, results)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+//how to pick addresses from an ipv6 range larger than max_per_prefix
+const (
+	//evenly spaced addresses across the range
+	ipv6SampleStride = "stride"
+	//random addresses in the range
+	ipv6SampleRandom = "random"
+)
+
+const defaultIPv6MaxPerPrefix = 256
+
+//ipv6Seed the seed of the random ipv6 sampling, checkpoints keep it so a
+//resumed scan samples the same addresses
+var ipv6Seed = time.Now().UnixNano()
+var ipv6Rand = rand.New(rand.NewSource(ipv6Seed))
+
+//seedIPv6 sample ipv6 ranges with seed
+func seedIPv6(seed int64) {
+	ipv6Seed = seed
+	ipv6Rand = rand.New(rand.NewSource(seed))
+}
+
+//initIPv6 check the ipv6 sampling config
+func initIPv6() {
+	switch config.IPv6.Sample {
+	case ipv6SampleStride, ipv6SampleRandom:
+	case "":
+		config.IPv6.Sample = ipv6SampleStride
+	default:
+		checkErr("parse config file error: ", fmt.Errorf("unknown ipv6 sample %q", config.IPv6.Sample), Error)
+	}
+	if config.IPv6.MaxPerPrefix <= 0 {
+		config.IPv6.MaxPerPrefix = defaultIPv6MaxPerPrefix
+	}
+}
+
+/**
+  Parse google ipv6 range, support the following formats:
+  1. xxxx:xxxx::xxxx
+  2. xxxx:xxxx::/xx, the subnet-router anycast address is skipped
+  3. xxxx:xxxx::xxxx-xxxx:xxxx::xxxx
+*/
+func parseGoogleIPv6Range(ipRange string) []string {
+	var first, last *big.Int
+	if strings.Contains(ipRange, "/") {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		checkErr(fmt.Sprintf("parse CIDR %s error: ", ipRange), err, Error)
+		ones, bits := ipNet.Mask.Size()
+		first = new(big.Int).SetBytes(ipNet.IP.To16())
+		size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+		last = new(big.Int).Add(first, size)
+		last.Sub(last, big.NewInt(1))
+		if size.Cmp(big.NewInt(1)) > 0 {
+			first.Add(first, big.NewInt(1))
+		}
+	} else if strings.Contains(ipRange, "-") {
+		startIP := net.ParseIP(ipRange[:strings.Index(ipRange, "-")])
+		endIP := net.ParseIP(ipRange[strings.Index(ipRange, "-")+1:])
+		if startIP == nil || endIP == nil {
+			checkErr(fmt.Sprintf("parse ipv6 range %s error: ", ipRange), fmt.Errorf("invalid ip range"), Error)
+		}
+		first = new(big.Int).SetBytes(startIP.To16())
+		last = new(big.Int).SetBytes(endIP.To16())
+	} else {
+		if net.ParseIP(ipRange) == nil {
+			checkErr(fmt.Sprintf("parse ipv6 %s error: ", ipRange), fmt.Errorf("invalid ip"), Error)
+		}
+		return []string{ipRange}
+	}
+
+	if first.Cmp(last) > 0 {
+		return nil
+	}
+	count := new(big.Int).Sub(last, first)
+	count.Add(count, big.NewInt(1))
+	max := big.NewInt(int64(config.IPv6.MaxPerPrefix))
+
+	var ips []string
+	if count.Cmp(max) <= 0 {
+		for n := new(big.Int).Set(first); n.Cmp(last) <= 0; n.Add(n, big.NewInt(1)) {
+			ips = append(ips, bigToIP(n).String())
+		}
+		return ips
+	}
+
+	switch config.IPv6.Sample {
+	case ipv6SampleRandom:
+		seen := make(map[string]bool)
+		for len(ips) < config.IPv6.MaxPerPrefix {
+			n := new(big.Int).Rand(ipv6Rand, count)
+			ip := bigToIP(n.Add(n, first)).String()
+			if !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	default:
+		stride := new(big.Int).Div(count, max)
+		for i := 0; i < config.IPv6.MaxPerPrefix; i++ {
+			n := new(big.Int).Mul(stride, big.NewInt(int64(i)))
+			ips = append(ips, bigToIP(n.Add(n, first)).String())
+		}
+	}
+	return ips
+}
+
+//bigToIP convert n to a 16 bytes ip
+func bigToIP(n *big.Int) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	b := n.Bytes()
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
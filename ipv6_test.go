@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseGoogleIPv6Range(t *testing.T) {
+	saved := config.IPv6
+	defer func() {
+		config.IPv6 = saved
+	}()
+	config.IPv6 = IPv6{Sample: ipv6SampleStride, MaxPerPrefix: 4}
+
+	tests := []struct {
+		ipRange string
+		want    []string
+	}{
+		{"2404:6800:4008:c01::8a", []string{"2404:6800:4008:c01::8a"}},
+		//the subnet-router anycast address is skipped
+		{"2404:6800:4008:c01::/126", []string{"2404:6800:4008:c01::1", "2404:6800:4008:c01::2", "2404:6800:4008:c01::3"}},
+		{"2404:6800:4008:c01::8/128", []string{"2404:6800:4008:c01::8"}},
+		{"2404:6800:4008:c01::fe-2404:6800:4008:c01::101", []string{"2404:6800:4008:c01::fe", "2404:6800:4008:c01::ff", "2404:6800:4008:c01::100", "2404:6800:4008:c01::101"}},
+		{"2404:6800:4008:c01::9-2404:6800:4008:c01::8", nil},
+		//larger than max_per_prefix, evenly spaced
+		{"2404:6800:4008:c01::/120", []string{"2404:6800:4008:c01::1", "2404:6800:4008:c01::40", "2404:6800:4008:c01::7f", "2404:6800:4008:c01::be"}},
+	}
+	for _, tt := range tests {
+		if got := parseGoogleIPRange(tt.ipRange); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseGoogleIPRange(%q) = %v, want %v", tt.ipRange, got, tt.want)
+		}
+	}
+}
+
+func TestParseGoogleIPv6RangeRandom(t *testing.T) {
+	saved, savedSeed := config.IPv6, ipv6Seed
+	defer func() {
+		config.IPv6 = saved
+		seedIPv6(savedSeed)
+	}()
+	config.IPv6 = IPv6{Sample: ipv6SampleRandom, MaxPerPrefix: 16}
+
+	_, ipNet, _ := net.ParseCIDR("2404:6800:4008::/48")
+	seedIPv6(1)
+	ips := parseGoogleIPv6Range("2404:6800:4008::/48")
+	if len(ips) != 16 {
+		t.Fatalf("sampled %d ips, want 16", len(ips))
+	}
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if !ipNet.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s is out of the range", ip)
+		}
+		if seen[ip] {
+			t.Errorf("%s is sampled twice", ip)
+		}
+		seen[ip] = true
+	}
+	//the same seed samples the same ips
+	seedIPv6(1)
+	if again := parseGoogleIPv6Range("2404:6800:4008::/48"); !reflect.DeepEqual(again, ips) {
+		t.Errorf("seed 1 sampled %v, then %v", ips, again)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -10,7 +11,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"os"
 	"path/filepath"
 	"sort"
@@ -23,7 +23,10 @@ type Config struct {
 	Concurrency      int      `json:"concurrency"`
 	Timeout          int      `json:"timeout"`
 	HandshakeTimeout int      `json:"handshake_timeout"`
+	Port             int      `json:"port"`
 	Delay            int      `json:"delay"`
+	DelayMetric      string   `json:"delay_metric"`
+	DelayStat        string   `json:"delay_stat"`
 	OnlyGWSIP        bool     `json:"only_gws_ip"`
 	OrgNames         []string `json:"organization"`
 	GwsDomains       []string `json:"gws"`
@@ -33,7 +36,31 @@ type Config struct {
 	CheckLastOkIP    bool     `json:"check_last_okip"`
 	SoftMode         bool     `json:"soft_mode"`
 	Bell             bool     `json:"bell"`
+	GracePeriod      int      `json:"grace_period"`
+	CheckpointEvery  int      `json:"checkpoint_interval"`
+	CheckHTTP2       bool     `json:"check_http2"`
+	HTTP2Preface     bool     `json:"http2_preface"`
+	SNI              []string `json:"sni"`
+	SNISelect        string   `json:"sni_select"`
 	IPPool           `json:"ippool"`
+	ScanOrder        `json:"scan_order"`
+	HTTPCheck        `json:"check_http"`
+	VerifyCert       `json:"verify_cert"`
+	Pinning          `json:"pinning"`
+	Samples          `json:"samples"`
+	Retry            map[string]RetryPolicy `json:"retry"`
+	Adaptive         `json:"adaptive_concurrency"`
+	IPv6             `json:"ipv6"`
+	QUIC             `json:"check_quic"`
+	SNIProxy         `json:"sni_proxy"`
+	GAECheck         `json:"check_gae"`
+	Resume           `json:"check_resume"`
+	RateLimit        `json:"rate_limit"`
+	Proxies          []Proxy  `json:"proxy"`
+	Uplinks          []Uplink `json:"uplinks"`
+	Probers          []string `json:"probers"`
+	Rules            []Rule   `json:"rules"`
+	OnlyClasses      []string `json:"only_classes"`
 	Bandwidth        `json:"check_bandwidth"`
 	GoProxy          `json:"write_to_goproxy"`
 }
@@ -48,10 +75,48 @@ type IPPool struct {
 
 //Bandwidth check bandwidth
 type Bandwidth struct {
-	Enabled     bool `json:"enabled"`
-	Sort        bool `json:"sort"`
-	Concurrency int  `json:"concurrency"`
-	Timeout     int  `json:"timeout"`
+	Enabled     bool     `json:"enabled"`
+	Sort        bool     `json:"sort"`
+	Concurrency int      `json:"concurrency"`
+	Timeout     int      `json:"timeout"`
+	SkipClasses []string `json:"skip_classes"`
+}
+
+//HTTPCheck send a request after handshake and verify the response
+type HTTPCheck struct {
+	Enabled bool     `json:"enabled"`
+	Method  string   `json:"method"`
+	Host    string   `json:"host"`
+	Path    string   `json:"path"`
+	Status  []int    `json:"status"`
+	Server  []string `json:"server"`
+}
+
+//VerifyCert verify the peer certificate chain
+type VerifyCert struct {
+	Mode        string `json:"mode"`
+	SystemRoots bool   `json:"system_roots"`
+	Hostname    string `json:"hostname"`
+}
+
+//Samples probe every ip several times
+type Samples struct {
+	Count   int     `json:"count"`
+	Warmup  bool    `json:"warmup"`
+	MaxLoss float64 `json:"max_loss"`
+}
+
+//IPv6 sample ipv6 ranges which are too large to enumerate
+type IPv6 struct {
+	Sample       string `json:"sample"`
+	MaxPerPrefix int    `json:"max_per_prefix"`
+}
+
+//QUIC check whether ip can be reached by QUIC on udp 443
+type QUIC struct {
+	Enabled    bool     `json:"enabled"`
+	ALPN       []string `json:"alpn"`
+	OnlyQUICIP bool     `json:"only_quic_ip"`
 }
 
 //GoProxy write ip to goproxy config
@@ -62,13 +127,14 @@ type GoProxy struct {
 }
 
 const (
-	configFileName   string = "main.json"
-	certFileName     string = "cacert.pem"
-	googleIPFileName string = "googleip.txt"
-	tmpOkIPFileName  string = "ip_tmpok.txt"
-	tmpErrIPFileName string = "ip_tmperr.txt"
-	tmpNoIPFileName  string = "ip_tmpno.txt"
-	jsonIPFileName   string = "ip.txt"
+	configFileName           string = "main.json"
+	certFileName             string = "cacert.pem"
+	googleIPFileName         string = "googleip.txt"
+	tmpOkIPFileName          string = "ip_tmpok.txt"
+	tmpErrIPFileName         string = "ip_tmperr.txt"
+	tmpNoIPFileName          string = "ip_tmpno.txt"
+	tmpInterceptedIPFileName string = "ip_tmpintercepted.txt"
+	jsonIPFileName           string = "ip.txt"
 )
 
 var config Config
@@ -76,8 +142,10 @@ var curDir string
 var separator string
 var certPool *x509.CertPool
 var tlsConfig *tls.Config
-var dialer net.Dialer
-var totalips chan string
+var sniTLSConfigs map[string]*tls.Config
+var sniList []string
+var bandwidthTLSConfig *tls.Config
+var totalips chan queuedIP
 
 func init() {
 	fmt.Println("initial...")
@@ -87,52 +155,101 @@ func init() {
 		config.Timeout = config.IPPool.Delay
 		config.HandshakeTimeout = config.IPPool.Delay
 	}
+	//gvs ip used to be skipped always
+	if config.Bandwidth.SkipClasses == nil {
+		config.Bandwidth.SkipClasses = []string{"gvs"}
+	}
 	loadCertPem()
-	createFile()
 	tlsConfig = &tls.Config{
 		RootCAs:            certPool,
 		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
 	}
+	//bandwidth check speaks plain HTTP/1.1, so never negotiate h2 there
+	bandwidthTLSConfig = tlsConfig.Clone()
+	bandwidthTLSConfig.NextProtos = []string{"http/1.1"}
+	initSNI()
+	initVerifyCert()
+	initDelayMetric()
+	checkRetryPolicy()
+	initIPv6()
+	initQUIC()
+	initProxy()
+	initRules()
+	initPinning()
+	initAdaptive()
+	initScanOrder()
 }
 
 func main() {
 
 	flag.Set("logtostderr", "true")
 	flag.Parse()
+	initCheckpoint()
+	createFile()
+	initRateLimit()
+	initUplinks()
+	initProbers()
+	initSNIProxy()
+	initResume()
+
+	handleSignals()
 
 	var lastOkIPs []string
 	var ips []string
-	if config.CheckLastOkIP {
+	//a resumed scan keeps the results so far and only rechecks the last ok
+	//ips which were not checked before it stopped
+	if *resumeScan {
+		lastOkIPs = resumed.LastOkIPs
+	} else if config.CheckLastOkIP {
 		tmpLastOkIPs := getLastOkIP()
+		//an ip is recorded once per uplink
+		addresses := make(map[string]string)
 		for _, ip := range tmpLastOkIPs {
-			lastOkIPs = append(lastOkIPs, ip.Address)
+			addresses[ip.Address] = ip.Address
 		}
+		lastOkIPs = convertMap2Array(addresses)
 		err := os.Truncate(tmpOkIPFileName, 0)
 		checkErr(fmt.Sprintf("truncate file %s error: ", tmpOkIPFileName), err, Error)
 	}
 
 	if config.SoftMode {
-		totalips = make(chan string, config.Concurrency)
+		tracker.trackLastOk(lastOkIPs)
+		totalips = make(chan queuedIP, config.Concurrency)
 		go func() {
+			defer close(totalips)
 			for _, ip := range lastOkIPs {
-				totalips <- ip
+				select {
+				case totalips <- queuedIP{Address: ip, seq: -1, lastOk: true}:
+				case <-dispatchCtx.Done():
+					return
+				}
 			}
-			getGoogleIPQueue()
-			close(totalips)
+			getGoogleIPQueue(dispatchCtx, resumed.scanPos)
 		}()
 
 		fmt.Printf("load last checked ip ok, count: %d,\nload extra ip ok, line: %d\n\n", len(lastOkIPs), len(getGoogleIPRange()))
+		if *resumeScan {
+			fmt.Printf("resume from ip %d\n\n", resumed.Index)
+		}
+		if config.ScanOrder.Random {
+			fmt.Printf("scan in random order, seed: %d\n\n", scanSeed)
+		}
 		time.Sleep(5 * time.Second)
 
 	} else {
 		ipsExtra := convertMap2Array(getUniqueGoogleIP())
+		shuffleIPs(ipsExtra)
 		ips = append(lastOkIPs, ipsExtra...)
 
 		fmt.Printf("load last checked ip ok, count: %d,\nload extra ip ok, line: %d, count: %d\n\n", len(lastOkIPs), len(getGoogleIPRange()), len(ips))
+		if config.ScanOrder.Random {
+			fmt.Printf("scan in random order, seed: %d\n\n", scanSeed)
+		}
 		time.Sleep(5 * time.Second)
 	}
 
-	jobs := make(chan string, config.Concurrency)
+	jobs := make(chan queuedIP, config.Concurrency)
 	done := make(chan bool, config.Concurrency)
 	maxNum := make(chan<- bool, config.IPPool.MaxIPNnumber)
 
@@ -141,27 +258,71 @@ func main() {
 	go func() {
 		if config.SoftMode {
 			for ip := range totalips {
-				jobs <- ip
+				select {
+				case jobs <- ip:
+				case <-dispatchCtx.Done():
+					close(jobs)
+					return
+				}
 			}
 		} else {
 			for _, ip := range ips {
-				jobs <- ip
+				select {
+				case jobs <- queuedIP{Address: ip, seq: -1}:
+				case <-dispatchCtx.Done():
+					close(jobs)
+					return
+				}
 			}
 		}
 		close(jobs)
 	}()
+	//the probes only see the config as it was when the scan started
+	scan := snapshotConfig()
+	probeCtx, cancelProbes := context.WithCancel(scanCtx)
+	stopCheckpoints := make(chan struct{})
+	if tracker != nil {
+		go saveCheckpoints(stopCheckpoints)
+	}
 	for ip := range jobs {
-		done <- true
-		go checkIP(ip, done, maxNum)
+		if dispatchCtx.Err() != nil {
+			continue
+		}
+		for _, uplink := range uplinks {
+			controller.acquire()
+			done <- true
+			go func(ip queuedIP, uplink *Uplink) {
+				defer func() {
+					<-done
+				}()
+				tracker.finish(ip, checkIP(probeCtx, scan, ip.Address, uplink, maxNum))
+			}(ip, uplink)
+		}
 		if config.IPPool.Enabled && len(maxNum) == config.IPPool.MaxIPNnumber {
-			break
+			//the results of the probes in flight would be dropped anyway
+			stopDispatch()
+			cancelProbes()
 		}
 	}
-	if !config.IPPool.Enabled {
-		for i := 0; i < cap(done); i++ {
-			done <- true
+	for i := 0; i < cap(done); i++ {
+		done <- true
+	}
+	cancelProbes()
+	if tracker != nil {
+		close(stopCheckpoints)
+		if dispatchCtx.Err() != nil {
+			saveCheckpoint()
 		}
 	}
+	//the whole googleip.txt is done, also when the resumed scan writes no
+	//checkpoints of its own
+	if dispatchCtx.Err() == nil && (tracker != nil || *resumeScan) {
+		os.Remove(checkpointFileName)
+	}
+	//after an interrupt the bandwidth check only keeps the ips
+	if interrupted.Load() {
+		stopScan()
+	}
 	//check all goole ip end
 
 	if config.Bandwidth.Enabled {
@@ -181,7 +342,7 @@ func main() {
 		}()
 		for ip := range jobs {
 			done <- true
-			go checkBandwidth(ip, done)
+			go checkBandwidth(scanCtx, scan, ip, done)
 		}
 		for i := 0; i < cap(done); i++ {
 			done <- true
@@ -189,10 +350,13 @@ func main() {
 		// t3 := time.Now()
 		// cost := int(t3.Sub(t2).Seconds())
 	}
-	gws, gvs, gpips := writeJSONIP2File()
+	total, classes, gpips := writeJSONIP2File()
 	t1 := time.Now()
 	cost := int(t1.Sub(t0).Seconds())
-	fmt.Printf("\ntime: %ds, ok ip count: %d(gws: %d, gvs: %d)\n\n", cost, gws+gvs, gws, gvs)
+	fmt.Printf("\ntime: %ds, ok ip count: %d(%s)\n\n", cost, total, formatClassCount(classes))
+	if limiter != nil {
+		fmt.Printf("%s\n\n", limiter.summary())
+	}
 	if config.GoProxy.Enabled {
 		file := filepath.Join(config.GoProxy.Path, "gae.user.json")
 		if !isFileExist(file) {
@@ -242,6 +406,17 @@ func parseConfig() {
 	checkErr("parse config file error: ", err, Error)
 }
 
+//snapshotConfig return a deep copy of config for the probes of a scan, so
+//they never see a config which changes under them
+func snapshotConfig() *Config {
+	data, err := json.Marshal(config)
+	checkErr("marshal config error: ", err, Error)
+	var c Config
+	err = json.Unmarshal(data, &c)
+	checkErr("unmarshal config error: ", err, Error)
+	return &c
+}
+
 //Load cacert.pem
 func loadCertPem() {
 	certpem, err := ioutil.ReadFile(certFileName)
@@ -252,145 +427,52 @@ func loadCertPem() {
 	}
 }
 
-func checkIP(ip string, done chan bool, maxNum chan<- bool) {
-	defer func() {
-		<-done
-	}()
-	var checkedip IP
-	checkedip.Address = ip
-	checkedip.Bandwidth = 0
-	checkedip.CountryName = "-"
-	dialer = net.Dialer{
-		Timeout:   time.Millisecond * time.Duration(config.Timeout),
-		KeepAlive: 0,
-		DualStack: false,
-	}
-
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(ip, "443"))
-	if err != nil {
-		if config.IPPool.Enabled && config.IPPool.MaxIPNnumber == len(maxNum) {
-			return
+//checkIP check ip over uplink with the scan config cfg and append it to the
+//result files, it returns false if the check was cancelled before the ip
+//was judged
+func checkIP(ctx context.Context, cfg *Config, ip string, uplink *Uplink, maxNum chan<- bool) bool {
+	probe := newProbe(cfg, ip, uplink)
+	defer probe.Close()
+	status, _ := probe.run(ctx)
+	checkedip := probe.IP
+	controller.release(checkedip)
+
+	if status != okIP {
+		//an ip whose probe was cancelled has not really been checked
+		if ctx.Err() != nil {
+			return false
 		}
-		checkErr(fmt.Sprintf("%s dial error: ", ip), err, Debug)
-		appendIP2File(checkedip, tmpErrIPFileName)
-		return
-	}
-	defer conn.Close()
-
-	t0 := time.Now()
-	tlsClient := tls.Client(conn, tlsConfig)
-	tlsClient.SetDeadline(time.Now().Add(time.Millisecond * time.Duration(config.HandshakeTimeout)))
-	err = tlsClient.Handshake()
-
-	if err != nil {
-		if config.IPPool.Enabled && config.IPPool.MaxIPNnumber == len(maxNum) {
-			return
+		if cfg.IPPool.Enabled && cfg.IPPool.MaxIPNnumber == len(maxNum) {
+			return true
 		}
-		checkErr(fmt.Sprintf("%s handshake error: ", ip), err, Debug)
-		appendIP2File(checkedip, tmpErrIPFileName)
-		return
+		switch status {
+		case errIP:
+			appendIP2File(checkedip, tmpErrIPFileName)
+		case interceptedIP:
+			appendIP2File(checkedip, tmpInterceptedIPFileName)
+		default:
+			appendIP2File(checkedip, tmpNoIPFileName)
+		}
+		return true
 	}
-	defer tlsClient.Close()
-	t1 := time.Now()
 
-	if tlsClient.ConnectionState().PeerCertificates == nil {
-		if config.IPPool.Enabled && config.IPPool.MaxIPNnumber == len(maxNum) {
-			return
+	//only ips going to ip.txt fill the pool
+	if cfg.IPPool.Enabled && exportClass(checkedip.ServerName) {
+		select {
+		case maxNum <- true:
+		default:
+			return true
 		}
-		checkErr(fmt.Sprintf("%s peer certificates error: ", ip), errors.New("peer certificates is nil"), Debug)
-		appendIP2File(checkedip, tmpNoIPFileName)
-		return
 	}
+	appendIP2File(checkedip, tmpOkIPFileName)
 
-	checkedip.Delay = int(t1.Sub(t0).Seconds() * 1000)
-
-	peerCertSubject := tlsClient.ConnectionState().PeerCertificates[0].Subject
-	DNSNames := tlsClient.ConnectionState().PeerCertificates[0].DNSNames
-	checkedip.CommonName = peerCertSubject.CommonName
-	orgNames := peerCertSubject.Organization
-	if len(peerCertSubject.Organization) > 0 {
-		checkedip.OrgName = orgNames[0]
-	}
-	countryNames := peerCertSubject.Country
-	if len(countryNames) > 0 {
-		checkedip.CountryName = countryNames[0]
-	}
-
-	for _, org := range config.OrgNames {
-		if org == checkedip.OrgName {
-			for _, gws := range config.GwsDomains {
-				if config.MatchByDNSName {
-					for _, DNSName := range DNSNames {
-						if strings.HasPrefix(DNSName, gws) {
-							checkedip.ServerName = "gws"
-							checkedip.CommonName = DNSName
-
-							if config.IPPool.Enabled {
-								select {
-								case maxNum <- true:
-								default:
-									return
-								}
-							}
-							appendIP2File(checkedip, tmpOkIPFileName)
-							goto OK
-						}
-					}
-				} else {
-					if checkedip.CommonName == gws {
-						checkedip.ServerName = "gws"
-						if config.IPPool.Enabled {
-							select {
-							case maxNum <- true:
-							default:
-								return
-							}
-						}
-						appendIP2File(checkedip, tmpOkIPFileName)
-						goto OK
-					}
-				}
-			}
-			for _, gvs := range config.GvsDomains {
-				if config.MatchByDNSName {
-					for _, DNSName := range DNSNames {
-						if strings.HasPrefix(DNSName, gvs) {
-							checkedip.ServerName = "gvs"
-							checkedip.CommonName = DNSName
-							if config.IPPool.Enabled {
-								select {
-								case maxNum <- true:
-								default:
-									return
-								}
-							}
-							appendIP2File(checkedip, tmpOkIPFileName)
-							goto OK
-						}
-					}
-				} else {
-					if checkedip.CommonName == gvs {
-						checkedip.ServerName = "gvs"
-						if config.IPPool.Enabled {
-							select {
-							case maxNum <- true:
-							default:
-								return
-							}
-						}
-						appendIP2File(checkedip, tmpOkIPFileName)
-						goto OK
-					}
-				}
-			}
-			appendIP2File(checkedip, tmpNoIPFileName)
-		} else {
-			appendIP2File(checkedip, tmpNoIPFileName)
-		}
+	via := ""
+	if checkedip.Via != "" {
+		via = ", via: " + checkedip.Via
 	}
-OK:
-	checkErr(fmt.Sprintf("%s: %s %s %s %dms", checkedip.Address, checkedip.CommonName, checkedip.ServerName, checkedip.CountryName,
-		checkedip.Delay), errors.New(""), Info)
+	checkErr(fmt.Sprintf("%s: %s %s %s %s %dms(connect: %dms, total: %dms%s)", checkedip.Address, checkedip.CommonName, checkedip.ServerName,
+		checkedip.CountryName, checkedip.Protocol, checkedip.Delay, checkedip.ConnectDelay, checkedip.TotalDelay, via), errors.New(""), Info)
+	return true
 }
 
 //append ip to related file
@@ -399,75 +481,142 @@ func appendIP2File(checkedip IP, filename string) {
 	checkErr(fmt.Sprintf("open file %s error: ", filename), err, Error)
 	defer f.Close()
 
-	ipInfo := fmt.Sprintf("%s %dms %s %s %s\n", checkedip.Address, checkedip.Delay, checkedip.CommonName, checkedip.ServerName, checkedip.CountryName)
+	ipInfo := fmt.Sprintf("%s %dms %s %s %s", checkedip.Address, checkedip.Delay, checkedip.CommonName, checkedip.ServerName, checkedip.CountryName)
 	if config.Bandwidth.Enabled {
-		ipInfo = fmt.Sprintf("%s %dms %s %s %s %dKB/s\n", checkedip.Address, checkedip.Delay, checkedip.CommonName, checkedip.ServerName, checkedip.CountryName, checkedip.Bandwidth)
+		ipInfo = fmt.Sprintf("%s %dKB/s", ipInfo, checkedip.Bandwidth)
 	}
+	ipInfo += formatIPExtra(checkedip) + "\n"
 	_, err = f.WriteString(ipInfo)
 	checkErr(fmt.Sprintf("append ip to file %s error: ", filename), err, Error)
 	f.Close()
 }
 
-//Create files if they donnot exist, or truncate them.
+//Create files if they donnot exist, or truncate them. A resumed scan keeps
+//them.
 func createFile() {
 	if !isFileExist(tmpOkIPFileName) {
 		_, err := os.Create(tmpOkIPFileName)
 		checkErr(fmt.Sprintf("create file %s error: ", tmpOkIPFileName), err, Error)
 	}
-	_, err := os.Create(tmpNoIPFileName)
-	checkErr(fmt.Sprintf("create file %s error: ", tmpNoIPFileName), err, Error)
-	_, err = os.Create(tmpErrIPFileName)
-	checkErr(fmt.Sprintf("create file %s error: ", tmpErrIPFileName), err, Error)
+	for _, file := range []string{tmpNoIPFileName, tmpErrIPFileName, tmpInterceptedIPFileName} {
+		if *resumeScan && isFileExist(file) {
+			continue
+		}
+		_, err := os.Create(file)
+		checkErr(fmt.Sprintf("create file %s error: ", file), err, Error)
+	}
 }
 
 /**
 writeJSONIP2File: sorting ip, ridding duplicate ip, generating json ip and
 bar-separated ip
 */
-func writeJSONIP2File() (gws, gvs int, gpips string) {
+func writeJSONIP2File() (total int, classes map[string]int, gpips string) {
 	okIPs := getLastOkIP()
 	if config.SortOkIP {
-		sort.Sort(ByDelay{IPs(okIPs)})
+		sort.Sort(ByDelay{IPs(okIPs), &config})
 	}
 	err := os.Truncate(tmpOkIPFileName, 0)
 	checkErr(fmt.Sprintf("truncate file %s error: ", tmpOkIPFileName), err, Error)
-	var gaipbuf, gpipbuf bytes.Buffer
+	var selected []IP
+	classes = make(map[string]int)
 	for _, ip := range okIPs {
-		if ip.ServerName == "gws" {
-			gws++
-		}
-		if ip.ServerName == "gvs" {
-			gvs++
-		}
+		total++
+		classes[ip.ServerName]++
 		appendIP2File(ip, tmpOkIPFileName)
+		if !exportClass(ip.ServerName) {
+			continue
+		}
 		if config.IPPool.Enabled {
-			gaipbuf.WriteString(ip.Address)
-			gaipbuf.WriteString("|")
-			gpipbuf.WriteString("\"")
-			gpipbuf.WriteString(ip.Address)
-			gpipbuf.WriteString("\",")
+			selected = append(selected, ip)
 		} else {
-			if ip.Delay <= config.Delay {
-				if config.OnlyGWSIP {
-					if ip.ServerName == "gws" {
-						gaipbuf.WriteString(ip.Address)
-						gaipbuf.WriteString("|")
-						gpipbuf.WriteString("\"")
-						gpipbuf.WriteString(ip.Address)
-						gpipbuf.WriteString("\",")
-					}
-				} else {
-					gaipbuf.WriteString(ip.Address)
-					gaipbuf.WriteString("|")
-					gpipbuf.WriteString("\"")
-					gpipbuf.WriteString(ip.Address)
-					gpipbuf.WriteString("\",")
+			if ip.sortDelay(&config) <= config.Delay && (config.Samples.MaxLoss <= 0 || ip.Stats.Loss <= config.Samples.MaxLoss) &&
+				(!config.QUIC.OnlyQUICIP || ip.QUIC.OK) && (!config.GAECheck.OnlyGAEIP || ip.GAE.OK) {
+				selected = append(selected, ip)
+			}
+		}
+	}
+	gpips = writeIPs2File(selected, jsonIPFileName)
+	if proberEnabled(gaeProberName) {
+		//goproxy only gets the ips serving App Engine
+		var gaeIPs []IP
+		for _, ip := range selected {
+			if ip.GAE.OK {
+				gaeIPs = append(gaeIPs, ip)
+			}
+		}
+		_, gpips = joinIPs(gaeIPs)
+	}
+	if proberEnabled(sniProxyProberName) {
+		var sniProxies []IP
+		for _, ip := range okIPs {
+			if ip.ServerName == sniProxyClass {
+				sniProxies = append(sniProxies, ip)
+			}
+		}
+		writeIPs2File(sniProxies, sniProxyIPFileName)
+	}
+	if len(uplinks) > 1 {
+		//keep a separate pool for every uplink
+		for _, uplink := range uplinks {
+			var uplinkIPs []IP
+			for _, ip := range selected {
+				if ip.Uplink == uplink.Name {
+					uplinkIPs = append(uplinkIPs, ip)
 				}
+			}
+			writeIPs2File(uplinkIPs, uplinkIPFileName(uplink))
+		}
+	}
+	if config.GoProxy.Enabled && config.GoProxy.OneIPPerLine {
+		gpips = strings.Replace(gpips, ",\"", ",\r\n\t\t\t\"", -1)
+	}
+	return total, classes, gpips
+}
 
+//exportClass whether ip of class should be written to ip.txt, sni proxies
+//never are
+func exportClass(class string) bool {
+	if class == sniProxyClass {
+		return false
+	}
+	if len(config.OnlyClasses) > 0 {
+		for _, c := range config.OnlyClasses {
+			if c == class {
+				return true
 			}
 		}
+		return false
 	}
-	gaips := gaipbuf.String()
+	return !config.OnlyGWSIP || class == "gws"
+}
+
+//writeIPs2File write ips to file as bar-separated ip and json ip. It
+//returns the json ip.
+func writeIPs2File(ips []IP, file string) (gpips string) {
+	gaips, gpips := joinIPs(ips)
+	err := ioutil.WriteFile(file, []byte(gaips+"\n"+gpips), 0755)
+	checkErr(fmt.Sprintf("write ip to file %s error: ", file), err, Error)
+	return gpips
+}
+
+//joinIPs join ips as bar-separated ip and json ip, ip checked over several
+//uplinks is joined once
+func joinIPs(ips []IP) (gaips, gpips string) {
+	var gaipbuf, gpipbuf bytes.Buffer
+	written := make(map[string]bool)
+	for _, ip := range ips {
+		if written[ip.Address] {
+			continue
+		}
+		written[ip.Address] = true
+		gaipbuf.WriteString(ip.Address)
+		gaipbuf.WriteString("|")
+		gpipbuf.WriteString("\"")
+		gpipbuf.WriteString(ip.Address)
+		gpipbuf.WriteString("\",")
+	}
+	gaips = gaipbuf.String()
 	gpips = gpipbuf.String()
 
 	if strings.HasSuffix(gaips, "|") {
@@ -476,13 +625,7 @@ func writeJSONIP2File() (gws, gvs int, gpips string) {
 	if strings.HasSuffix(gpips, ",") {
 		gpips = strings.TrimSuffix(gpips, ",")
 	}
-
-	err = ioutil.WriteFile(jsonIPFileName, []byte(gaips+"\n"+gpips), 0755)
-	checkErr(fmt.Sprintf("write ip to file %s error: ", jsonIPFileName), err, Error)
-	if config.GoProxy.Enabled && config.GoProxy.OneIPPerLine {
-		gpips = strings.Replace(gpips, ",\"", ",\r\n\t\t\t\"", -1)
-	}
-	return gws, gvs, gpips
+	return gaips, gpips
 }
 
 //writeIP2Goproxy: write json ip to gae.user.json or gae.json
@@ -499,17 +642,19 @@ func writeIP2Goproxy(file, jsonips string) {
 		fmt.Println("write ip to .json file successfully.")
 	}
 }
-func checkBandwidth(ip IP, done chan bool) {
+func checkBandwidth(ctx context.Context, cfg *Config, ip IP, done chan bool) {
 	defer func() {
 		<-done
 	}()
 	ip.Bandwidth = 0
-	if ip.ServerName == "gvs" {
-		appendIP2File(ip, tmpOkIPFileName)
-		checkErr(fmt.Sprintf("%s %s %s NaN", ip.Address, ip.CommonName, ip.ServerName), errors.New("gvs skipped"), Info)
-		return
+	for _, class := range cfg.Bandwidth.SkipClasses {
+		if ip.ServerName == class {
+			appendIP2File(ip, tmpOkIPFileName)
+			checkErr(fmt.Sprintf("%s %s %s NaN", ip.Address, ip.CommonName, ip.ServerName), errors.New(class+" skipped"), Info)
+			return
+		}
 	}
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(ip.Address, "443"))
+	conn, err := uplinkByName(ip.Uplink).newDialer(cfg).DialContext(ctx, "tcp", tlsAddr(cfg, ip.Address))
 	if err != nil {
 		appendIP2File(ip, tmpOkIPFileName)
 		checkErr(fmt.Sprintf("%s dial error: ", ip.Address), err, Info)
@@ -517,8 +662,18 @@ func checkBandwidth(ip IP, done chan bool) {
 	}
 	defer conn.Close()
 
-	tlsClient := tls.Client(conn, tlsConfig)
+	tlsClient := tls.Client(conn, bandwidthTLSConfig)
 	tlsClient.SetDeadline(time.Now().Add(time.Minute * 5))
+	//the download is cut short when the scan is stopped
+	stop := context.AfterFunc(ctx, func() {
+		tlsClient.SetDeadline(time.Now())
+	})
+	defer stop()
+	if err = tlsClient.HandshakeContext(ctx); err != nil {
+		appendIP2File(ip, tmpOkIPFileName)
+		checkErr(fmt.Sprintf("%s handshake error: ", ip.Address), err, Info)
+		return
+	}
 	_, err = tlsClient.Write([]byte("GET /storage/v1/b/google-code-archive/o/v2%2Fcode.google.com%2Fgogo-tester%2Fwiki%2F1m.wiki?alt=media HTTP/1.1\r\nHost: www.googleapis.com\r\nConnection: close\r\n\r\n"))
 	if err != nil {
 		appendIP2File(ip, tmpOkIPFileName)
@@ -542,7 +697,9 @@ func checkBandwidth(ip IP, done chan bool) {
 	}
 	t1 := time.Now()
 
-	ip.Bandwidth = int(float64(len(buf)) / 1024 / t1.Sub(t0).Seconds())
+	if ctx.Err() == nil {
+		ip.Bandwidth = int(float64(len(buf)) / 1024 / t1.Sub(t0).Seconds())
+	}
 	appendIP2File(ip, tmpOkIPFileName)
 	checkErr(fmt.Sprintf("%s %s %s %dKB/s", ip.Address, ip.CommonName, ip.ServerName, ip.Bandwidth), errors.New(""), Info)
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+//ScanOrder scan the ips of googleip.txt in a random order, the same seed
+//gives the same order, 0 picks a new seed every scan
+type ScanOrder struct {
+	Random bool  `json:"random"`
+	Seed   int64 `json:"seed"`
+}
+
+//scanSeed the seed of the random scan order
+var scanSeed int64
+
+//initScanOrder pick the seed of the random scan order
+func initScanOrder() {
+	if !config.ScanOrder.Random {
+		return
+	}
+	seed := config.ScanOrder.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	setScanSeed(seed)
+}
+
+//setScanSeed use seed for the scan order, ipv6 ranges are sampled with it
+//too so the whole scan can be repeated
+func setScanSeed(seed int64) {
+	scanSeed = seed
+	seedIPv6(seed)
+}
+
+//shuffleIPs put ips in the random scan order, it does nothing unless
+//scan_order.random is set
+func shuffleIPs(ips []string) {
+	if !config.ScanOrder.Random {
+		return
+	}
+	//ips may come from a map, sort them so the seed alone decides the order
+	sort.Strings(ips)
+	r := rand.New(rand.NewSource(scanSeed))
+	r.Shuffle(len(ips), func(i, j int) {
+		ips[i], ips[j] = ips[j], ips[i]
+	})
+}
+
+//ipSpan the ips of a range, ipv4 ranges keep only their first address and
+//ipv6 ranges their sampled addresses
+type ipSpan struct {
+	first int64
+	count int
+	ips   []string
+}
+
+//newIPSpan parse ipRange without listing ipv4 addresses
+func newIPSpan(ipRange string) ipSpan {
+	if strings.Contains(ipRange, ":") {
+		ips := parseGoogleIPv6Range(ipRange)
+		return ipSpan{count: len(ips), ips: ips}
+	}
+	first, last := parseIPv4Range(ipRange)
+	if last < first {
+		return ipSpan{}
+	}
+	return ipSpan{first: first, count: int(last - first + 1)}
+}
+
+//ip return the ip at offset i of the span
+func (s ipSpan) ip(i int) string {
+	if s.ips != nil {
+		return s.ips[i]
+	}
+	return uint2IPv4(s.first + int64(i)).String()
+}
+
+//scanOrder number all ips of the ranges one after another, and map the
+//position in the scan to that number
+type scanOrder struct {
+	spans []ipSpan
+	//ends[i] is the number after the last ip of spans[i]
+	ends  []int
+	total int
+	perm  *permutation
+}
+
+//newScanOrder return the order to scan ipRanges in, it is random if
+//scan_order.random is set
+func newScanOrder(ipRanges []string) *scanOrder {
+	o := &scanOrder{}
+	for _, ipRange := range ipRanges {
+		span := newIPSpan(ipRange)
+		o.total += span.count
+		o.spans = append(o.spans, span)
+		o.ends = append(o.ends, o.total)
+	}
+	if config.ScanOrder.Random {
+		o.perm = newPermutation(uint64(o.total), scanSeed)
+	}
+	return o
+}
+
+//ip return the ip scanned at position i
+func (o *scanOrder) ip(i int) string {
+	n := i
+	if o.perm != nil {
+		n = int(o.perm.at(uint64(i)))
+	}
+	k := sort.Search(len(o.ends), func(k int) bool {
+		return o.ends[k] > n
+	})
+	return o.spans[k].ip(n - o.ends[k] + o.spans[k].count)
+}
+
+//feistelRounds rounds of the feistel network of permutation
+const feistelRounds = 4
+
+//permutation a seeded bijection of [0, n) which needs no memory per
+//element. A feistel network shuffles the smallest even power of two not
+//less than n, numbers out of [0, n) are shuffled again until they fall in.
+type permutation struct {
+	n    uint64
+	half uint
+	mask uint64
+	keys [feistelRounds]uint64
+}
+
+//newPermutation return the permutation of [0, n) for seed
+func newPermutation(n uint64, seed int64) *permutation {
+	p := &permutation{n: n}
+	if n > 1 {
+		p.half = uint(bits.Len64(n-1)+1) / 2
+	}
+	p.mask = 1<<p.half - 1
+	r := rand.New(rand.NewSource(seed))
+	for i := range p.keys {
+		p.keys[i] = r.Uint64()
+	}
+	return p
+}
+
+//at return the number at position i, i must be less than n
+func (p *permutation) at(i uint64) uint64 {
+	//the walk stays in [0, n) since the network is a bijection, at most
+	//4 steps are needed on average
+	for {
+		i = p.encrypt(i)
+		if i < p.n {
+			return i
+		}
+	}
+}
+
+//encrypt one pass of the feistel network
+func (p *permutation) encrypt(x uint64) uint64 {
+	l, r := x>>p.half, x&p.mask
+	for _, key := range p.keys {
+		l, r = r, l^(mix64(r^key)&p.mask)
+	}
+	return l<<p.half | r
+}
+
+//mix64 the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPermutation(t *testing.T) {
+	for _, n := range []uint64{0, 1, 2, 3, 4, 5, 7, 15, 16, 17, 100, 1000, 4097} {
+		p := newPermutation(n, 42)
+		seen := make([]bool, n)
+		for i := uint64(0); i < n; i++ {
+			v := p.at(i)
+			if v >= n {
+				t.Fatalf("n %d: at(%d) = %d is out of range", n, i, v)
+			}
+			if seen[v] {
+				t.Fatalf("n %d: at(%d) = %d is repeated", n, i, v)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestPermutationSeed(t *testing.T) {
+	order := func(seed int64) []uint64 {
+		p := newPermutation(1000, seed)
+		var values []uint64
+		for i := uint64(0); i < 1000; i++ {
+			values = append(values, p.at(i))
+		}
+		return values
+	}
+	if !reflect.DeepEqual(order(7), order(7)) {
+		t.Error("the same seed gives different orders")
+	}
+	if reflect.DeepEqual(order(7), order(8)) {
+		t.Error("different seeds give the same order")
+	}
+}
+
+func TestScanOrder(t *testing.T) {
+	saved, savedSeed := config.ScanOrder, scanSeed
+	defer func() {
+		config.ScanOrder, scanSeed = saved, savedSeed
+	}()
+
+	ipRanges := []string{"10.0.0.0/30", "10.0.1.0/31", "10.0.2.7/32", "10.0.3.5", "10.0.4.250-10.0.5.", "10.0.6.0/24"}
+	var want []string
+	for _, ipRange := range ipRanges {
+		want = append(want, parseGoogleIPRange(ipRange)...)
+	}
+
+	scan := func() []string {
+		o := newScanOrder(ipRanges)
+		if o.total != len(want) {
+			t.Fatalf("total = %d, want %d", o.total, len(want))
+		}
+		var ips []string
+		for i := 0; i < o.total; i++ {
+			ips = append(ips, o.ip(i))
+		}
+		return ips
+	}
+
+	config.ScanOrder.Random = false
+	if got := scan(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sequential order = %v, want %v", got, want)
+	}
+
+	config.ScanOrder.Random = true
+	setScanSeed(99)
+	random := scan()
+	if reflect.DeepEqual(random, want) {
+		t.Error("random order is sequential")
+	}
+	if again := scan(); !reflect.DeepEqual(random, again) {
+		t.Error("the same seed gives different orders")
+	}
+	sort.Strings(random)
+	sorted := append([]string(nil), want...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(random, sorted) {
+		t.Errorf("random order does not cover the ranges exactly once")
+	}
+}
+
+func TestIPSpanEmpty(t *testing.T) {
+	for _, ipRange := range []string{"10.0.0.0/31", "10.0.0.1/32", "10.0.0.9-10.0.0.8"} {
+		if span := newIPSpan(ipRange); span.count != 0 {
+			t.Errorf("%s: count = %d, want 0", ipRange, span.count)
+		}
+		if ips := parseGoogleIPRange(ipRange); len(ips) != 0 {
+			t.Errorf("%s: ips = %v, want none", ipRange, ips)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const pinPrefix = "sha256/"
+
+//Pinning require a certificate of the verified chain to have a pinned SPKI,
+//an ip failing it is considered intercepted
+type Pinning struct {
+	Enabled  bool     `json:"enabled"`
+	Pins     []string `json:"pins"`
+	PinFile  string   `json:"pin_file"`
+	ChainDir string   `json:"chain_dir"`
+}
+
+var pins map[string]bool
+
+//initPinning load the pins from config and the pin file, and create the
+//directory of intercepted chains
+func initPinning() {
+	if !config.Pinning.Enabled {
+		return
+	}
+	pins = make(map[string]bool)
+	for _, pin := range config.Pinning.Pins {
+		pins[strings.TrimPrefix(pin, pinPrefix)] = true
+	}
+	if config.Pinning.PinFile != "" {
+		data, err := ioutil.ReadFile(config.Pinning.PinFile)
+		checkErr(fmt.Sprintf("read pin file %s error: ", config.Pinning.PinFile), err, Error)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				pins[strings.TrimPrefix(line, pinPrefix)] = true
+			}
+		}
+	}
+	if len(pins) == 0 {
+		checkErr("parse config file error: ", fmt.Errorf("pinning is enabled without pins"), Error)
+	}
+	if config.Pinning.ChainDir != "" {
+		err := os.MkdirAll(config.Pinning.ChainDir, 0755)
+		checkErr(fmt.Sprintf("create directory %s error: ", config.Pinning.ChainDir), err, Error)
+	}
+}
+
+//spkiPin return the base64 sha256 of the SubjectPublicKeyInfo of cert
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//checkPins verify chain and require a certificate of a verified chain to
+//have a pinned SPKI. The presented certificates alone prove nothing, a
+//forged leaf can be sent with the public intermediate appended, and the
+//root a pin may name is usually not sent.
+func checkPins(chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("peer certificates is nil")
+	}
+	var presented []string
+	for _, cert := range chain {
+		presented = append(presented, cert.Subject.CommonName)
+	}
+	verified, err := chain[0].Verify(chainOptions(chain))
+	if err != nil {
+		return fmt.Errorf("verify chain %s error: %v", strings.Join(presented, " <- "), err)
+	}
+	for _, certs := range verified {
+		for _, cert := range certs {
+			if pins[spkiPin(cert)] {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no pinned key in chain %s", strings.Join(presented, " <- "))
+}
+
+//saveChain write chain of ip to the chain directory in PEM for inspection
+func saveChain(cfg *Config, ip string, chain []*x509.Certificate) {
+	if cfg.Pinning.ChainDir == "" {
+		return
+	}
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	//ipv6 colons are not allowed in windows file names
+	file := filepath.Join(cfg.Pinning.ChainDir, strings.Replace(ip, ":", "_", -1)+".pem")
+	err := ioutil.WriteFile(file, buf.Bytes(), 0644)
+	checkErr(fmt.Sprintf("write chain to file %s error: ", file), err, Warning)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+//testCert a certificate and its key, signed by parent or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, ca bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ca {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.DNSNames = []string{cn}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}
+}
+
+func TestCheckPins(t *testing.T) {
+	root := newTestCert(t, "Root CA", true, nil)
+	intermediate := newTestCert(t, "Intermediate CA", true, root)
+	leaf := newTestCert(t, "www.google.com", false, intermediate)
+	//an interception box whose CA is trusted by the scanning host
+	mitm := newTestCert(t, "MITM CA", true, nil)
+	forged := newTestCert(t, "www.google.com", false, mitm)
+
+	savedRoots, savedPins := verifyRoots, pins
+	defer func() {
+		verifyRoots, pins = savedRoots, savedPins
+	}()
+	verifyRoots = x509.NewCertPool()
+	verifyRoots.AddCert(root.cert)
+	verifyRoots.AddCert(mitm.cert)
+
+	tests := []struct {
+		name  string
+		pin   *testCert
+		chain []*x509.Certificate
+		ok    bool
+	}{
+		{"intermediate pin", intermediate, []*x509.Certificate{leaf.cert, intermediate.cert}, true},
+		{"root pin, root not sent", root, []*x509.Certificate{leaf.cert, intermediate.cert}, true},
+		{"forged leaf with the pinned intermediate appended", intermediate, []*x509.Certificate{forged.cert, intermediate.cert}, false},
+		{"forged leaf with the pinned root appended", root, []*x509.Certificate{forged.cert, intermediate.cert, root.cert}, false},
+		{"chain which does not verify", intermediate, []*x509.Certificate{leaf.cert}, false},
+		{"no pinned key", mitm, []*x509.Certificate{leaf.cert, intermediate.cert}, false},
+	}
+	for _, tt := range tests {
+		pins = map[string]bool{spkiPin(tt.pin.cert): true}
+		if err := checkPins(tt.chain); (err == nil) != tt.ok {
+			t.Errorf("%s: checkPins() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"golang.org/x/net/proxy"
+)
+
+//Prober check one aspect of an ip. Probe fills p.IP with what it finds and
+//returns okIP, noIP or errIP; the chain stops at the first prober which
+//does not return okIP. Probe should give up once ctx is done.
+type Prober interface {
+	Name() string
+	Probe(ctx context.Context, p *Probe) (status int, err error)
+}
+
+//Probe the state of checking one ip over one uplink, shared by the probers
+//of the chain
+type Probe struct {
+	IP IP
+	//Config of the scan, probers read it instead of the global config
+	Config *Config
+	Uplink *Uplink
+	//Dialer of this probe, made from the uplink
+	Dialer proxy.ContextDialer
+	//Conn and SNI are set by the tls prober, the http prober closes Conn
+	Conn *tls.Conn
+	SNI  string
+}
+
+//tlsProberName the prober every chain starts with
+const tlsProberName = "tls"
+
+var registeredProbers = make(map[string]Prober)
+var proberChain []Prober
+
+//registerProber make prober available to the probers config, it must be
+//called from init
+func registerProber(prober Prober) {
+	if _, ok := registeredProbers[prober.Name()]; ok {
+		panic("prober " + prober.Name() + " registered twice")
+	}
+	registeredProbers[prober.Name()] = prober
+}
+
+//initProbers build the prober chain from config. Without a probers config
+//the chain is made of the probers enabled by the older options.
+func initProbers() {
+	names := config.Probers
+	if len(names) == 0 {
+		names = []string{tlsProberName}
+		if config.CheckHTTP2 {
+			names = append(names, http2ProberName)
+		}
+		if config.HTTPCheck.Enabled {
+			names = append(names, httpProberName)
+		}
+		if config.QUIC.Enabled {
+			names = append(names, quicProberName)
+		}
+		if config.Resume.Enabled {
+			names = append(names, resumeProberName)
+		}
+		if config.GAECheck.Enabled {
+			names = append(names, gaeProberName)
+		}
+		if config.SNIProxy.Enabled {
+			names = append(names, sniProxyProberName)
+		}
+	}
+
+	//tls sets up the connection, it is moved to the front
+	chain := []string{tlsProberName}
+	listed := make(map[string]bool)
+	for _, name := range names {
+		if listed[name] {
+			checkErr("parse config file error: ", fmt.Errorf("prober %q listed twice", name), Error)
+		}
+		listed[name] = true
+		if name != tlsProberName {
+			chain = append(chain, name)
+		}
+	}
+	//the http prober closes p.Conn, which the http2 prober still needs
+	if listed[http2ProberName] && listed[httpProberName] && proberIndex(chain, http2ProberName) > proberIndex(chain, httpProberName) {
+		checkErr("parse config file error: ", fmt.Errorf("prober %q must run before %q", http2ProberName, httpProberName), Error)
+	}
+
+	//without a host the http check sends the SNI, which "none" does not have
+	if listed[httpProberName] && config.HTTPCheck.Host == "" {
+		for _, sni := range sniList {
+			if sni == sniNone {
+				checkErr("parse config file error: ", fmt.Errorf("check_http host is empty and sni %q has no name to use instead", sniNone), Error)
+			}
+		}
+	}
+	//no ip would be exported if the prober which sets the result never runs
+	if config.QUIC.OnlyQUICIP && !listed[quicProberName] {
+		checkErr("parse config file error: ", fmt.Errorf("only_quic_ip needs prober %q", quicProberName), Error)
+	}
+	if config.GAECheck.OnlyGAEIP && !listed[gaeProberName] {
+		checkErr("parse config file error: ", fmt.Errorf("only_gae_ip needs prober %q", gaeProberName), Error)
+	}
+
+	proberChain = nil
+	for _, name := range chain {
+		prober, ok := registeredProbers[name]
+		if !ok {
+			checkErr("parse config file error: ", fmt.Errorf("unknown prober %q", name), Error)
+		}
+		proberChain = append(proberChain, prober)
+	}
+}
+
+//proberIndex return the index of name in chain, or -1
+func proberIndex(chain []string, name string) int {
+	for i, n := range chain {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+//proberEnabled whether the prober with name is in the chain
+func proberEnabled(name string) bool {
+	for _, prober := range proberChain {
+		if prober.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+//newProbe start checking ip over uplink with the scan config cfg
+func newProbe(cfg *Config, ip string, uplink *Uplink) *Probe {
+	p := &Probe{Config: cfg, Uplink: uplink, Dialer: uplink.newDialer(cfg)}
+	p.IP.Address = ip
+	p.IP.CountryName = "-"
+	p.IP.Protocol = "-"
+	p.IP.Via = upstreamVia
+	p.IP.Uplink = uplink.Name
+	return p
+}
+
+//run run the prober chain and classify the ip by the rules, it returns the
+//status of the first prober which fails
+func (p *Probe) run(ctx context.Context) (status int, err error) {
+	for _, prober := range proberChain {
+		status, err = prober.Probe(ctx, p)
+		if status != okIP {
+			checkErr(fmt.Sprintf("%s %s prober: ", p.IP.Address, prober.Name()), err, Debug)
+			if p.IP.FailureClass == "" {
+				p.IP.FailureClass = classifyError(err)
+			}
+			return status, err
+		}
+	}
+	//sni proxies serve anything, the rules would put them in the gws/gvs
+	//pools
+	if p.IP.ServerName == sniProxyClass {
+		return okIP, nil
+	}
+	if !classifyIP(&p.IP, p.Conn.ConnectionState().PeerCertificates[0]) {
+		p.IP.FailureClass = failCertMismatch
+		return noIP, fmt.Errorf("certificate %s of %s matches no rule", p.IP.CommonName, p.IP.OrgName)
+	}
+	return okIP, nil
+}
+
+//Close close the connection of the probe
+func (p *Probe) Close() {
+	if p.Conn != nil {
+		p.Conn.Close()
+	}
+}
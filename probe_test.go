@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInitProbers(t *testing.T) {
+	saved, savedChain := config.Probers, proberChain
+	defer func() {
+		config.Probers, proberChain = saved, savedChain
+	}()
+
+	tests := []struct {
+		probers []string
+		want    []string
+	}{
+		{[]string{"http", "tls"}, []string{"tls", "http"}},
+		{[]string{"http2", "tls", "http", "quic"}, []string{"tls", "http2", "http", "quic"}},
+		{[]string{"gae"}, []string{"tls", "gae"}},
+	}
+	for _, tt := range tests {
+		config.Probers = tt.probers
+		initProbers()
+		var names []string
+		for _, prober := range proberChain {
+			names = append(names, prober.Name())
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("probers %v: chain = %v, want %v", tt.probers, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//upstream proxy type
+const (
+	proxySOCKS5 = "socks5"
+	proxyHTTP   = "http"
+)
+
+//Proxy an upstream proxy, probes are dialed through all configured proxies
+//in order
+type Proxy struct {
+	Type     string `json:"type"`
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+//upstreamVia describe the proxy chain, it is empty for direct connections
+var upstreamVia string
+
+//initProxy check the proxy chain
+func initProxy() {
+	var via []string
+	for _, p := range config.Proxies {
+		switch p.Type {
+		case proxySOCKS5, proxyHTTP:
+		default:
+			checkErr("parse config file error: ", fmt.Errorf("unknown proxy type %q", p.Type), Error)
+		}
+		via = append(via, p.Type+"://"+p.Address)
+	}
+	upstreamVia = strings.Join(via, ",")
+	if upstreamVia != "" && config.QUIC.Enabled {
+		checkErr("check_quic: ", fmt.Errorf("quic probes cannot use the tcp proxy chain and are dialed directly"), Warning)
+	}
+}
+
+//proxyChain return a dialer which goes through all configured proxies,
+//the first proxy is dialed by forward
+func proxyChain(cfg *Config, forward proxy.ContextDialer) proxy.ContextDialer {
+	for _, p := range cfg.Proxies {
+		switch p.Type {
+		case proxySOCKS5:
+			var auth *proxy.Auth
+			if p.Username != "" {
+				auth = &proxy.Auth{User: p.Username, Password: p.Password}
+			}
+			d, err := proxy.SOCKS5("tcp", p.Address, auth, forward.(proxy.Dialer))
+			checkErr(fmt.Sprintf("create socks5 proxy %s error: ", p.Address), err, Error)
+			forward = d.(proxy.ContextDialer)
+		case proxyHTTP:
+			forward = &httpConnectDialer{
+				proxy:   p,
+				forward: forward,
+				timeout: time.Millisecond * time.Duration(cfg.Timeout),
+			}
+		}
+	}
+	return forward
+}
+
+//httpConnectDialer dial through an http proxy with the CONNECT method
+type httpConnectDialer struct {
+	proxy   Proxy
+	forward proxy.ContextDialer
+	timeout time.Duration
+}
+
+//Dial connect to addr through the http proxy
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+//DialContext connect to addr through the http proxy, the CONNECT request
+//is aborted when ctx is done
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.proxy.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(d.timeout))
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.proxy.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(d.proxy.Username + ":" + d.proxy.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s CONNECT %s: %s", d.proxy.Address, addr, resp.Status)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
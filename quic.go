@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+var defaultQUICALPN = []string{"h3"}
+
+const quicProberName = "quic"
+
+//quicProber record whether ip can be reached by QUIC, it never rejects an ip
+type quicProber struct{}
+
+func init() {
+	registerProber(quicProber{})
+}
+
+//Name of quicProber
+func (quicProber) Name() string {
+	return quicProberName
+}
+
+//Probe do a QUIC handshake with p.IP
+func (quicProber) Probe(ctx context.Context, p *Probe) (int, error) {
+	p.IP.QUIC = checkQUIC(ctx, p.Config, p.Uplink, p.IP.Address, p.SNI)
+	return okIP, nil
+}
+
+//QUICResult result of the QUIC handshake with an ip
+type QUICResult struct {
+	Checked    bool
+	OK         bool
+	Delay      int
+	Protocol   string
+	CommonName string
+	//only checked with check_resume
+	Ticket    bool
+	EarlyData bool
+}
+
+var quicTLSConfigs map[string]*tls.Config
+
+//initQUIC prepare a tls config offering the QUIC ALPN for every SNI
+func initQUIC() {
+	if len(config.QUIC.ALPN) == 0 {
+		config.QUIC.ALPN = defaultQUICALPN
+	}
+	quicTLSConfigs = make(map[string]*tls.Config)
+	for sni, c := range sniTLSConfigs {
+		c = c.Clone()
+		c.NextProtos = config.QUIC.ALPN
+		quicTLSConfigs[sni] = c
+	}
+}
+
+//checkQUIC do a QUIC handshake with ip on the udp port through uplink using
+//the given SNI
+func checkQUIC(ctx context.Context, cfg *Config, uplink *Uplink, ip, sni string) QUICResult {
+	result := QUICResult{Checked: true}
+	timeout := time.Millisecond * time.Duration(cfg.HandshakeTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	raddr, err := net.ResolveUDPAddr("udp", tlsAddr(cfg, ip))
+	if err != nil {
+		checkErr(ip+" quic resolve error: ", err, Debug)
+		return result
+	}
+	pconn, err := uplink.listenUDP(ctx)
+	if err != nil {
+		checkErr(ip+" quic listen error: ", err, Debug)
+		return result
+	}
+	defer pconn.Close()
+
+	c := quicTLSConfigs[sni]
+	var tickets *ticketCache
+	if proberEnabled(resumeProberName) {
+		tickets = newTicketCache()
+		c = c.Clone()
+		c.ClientSessionCache = tickets
+	}
+
+	if err = limiter.wait(ctx, ip); err != nil {
+		return result
+	}
+	t0 := time.Now()
+	conn, err := quic.Dial(ctx, pconn, raddr, c, &quic.Config{
+		HandshakeIdleTimeout: timeout,
+	})
+	if err != nil {
+		checkErr(ip+" quic handshake error: ", err, Debug)
+		return result
+	}
+	defer conn.CloseWithError(0, "")
+
+	result.OK = true
+	result.Delay = toMillisecond(time.Since(t0))
+	state := conn.ConnectionState().TLS
+	result.Protocol = state.NegotiatedProtocol
+	if len(state.PeerCertificates) > 0 {
+		result.CommonName = state.PeerCertificates[0].Subject.CommonName
+	}
+	if tickets != nil {
+		//only QUIC session tickets tell whether 0-RTT is allowed
+		select {
+		case session := <-tickets.tickets:
+			result.Ticket = true
+			result.EarlyData = session.EarlyData
+		case <-time.After(resumeTicketWait):
+		case <-ctx.Done():
+		}
+	}
+	return result
+}
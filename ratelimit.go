@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//RateLimit limit the new connections per second, globally and per subnet
+//(/24 for ipv4, /48 for ipv6). A rate of 0 means no limit.
+type RateLimit struct {
+	Rate        float64 `json:"rate"`
+	Burst       int     `json:"burst"`
+	SubnetRate  float64 `json:"subnet_rate"`
+	SubnetBurst int     `json:"subnet_burst"`
+}
+
+var connRate = flag.Float64("rate", 0, "new connections per second, overrides rate_limit in config")
+var subnetConnRate = flag.Float64("subnet-rate", 0, "new connections per second to a /24 (IPv4) or /48 (IPv6), overrides rate_limit in config")
+
+//subnetSweepSize the number of subnet buckets above which idle ones are
+//dropped
+const subnetSweepSize = 4096
+
+//tokenBucket a token bucket refilled at rate tokens per second up to burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+//reserve take a token and return how long to wait until it is available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+//idle whether the bucket has refilled completely
+func (b *tokenBucket) idle(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+//connLimiter delay new connections to stay within the rate limit
+type connLimiter struct {
+	mu      sync.Mutex
+	global  *tokenBucket
+	subnets map[string]*tokenBucket
+
+	conns   int
+	delayed int
+	waited  time.Duration
+}
+
+//limiter is nil if there is no rate limit
+var limiter *connLimiter
+
+//initRateLimit set up the limiter from config and flags, it must be called
+//after the flags are parsed and before initUplinks
+func initRateLimit() {
+	if *connRate > 0 {
+		config.RateLimit.Rate = *connRate
+	}
+	if *subnetConnRate > 0 {
+		config.RateLimit.SubnetRate = *subnetConnRate
+	}
+	if config.RateLimit.Rate <= 0 && config.RateLimit.SubnetRate <= 0 {
+		return
+	}
+	limiter = &connLimiter{subnets: make(map[string]*tokenBucket)}
+	if config.RateLimit.Rate > 0 {
+		limiter.global = newTokenBucket(config.RateLimit.Rate, config.RateLimit.Burst, time.Now())
+	}
+}
+
+//subnetOf return the /24 of an ipv4 host or the /48 of an ipv6 host
+func subnetOf(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+//wait block until a new connection to host is allowed or ctx is done
+func (l *connLimiter) wait(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	var delay time.Duration
+	if l.global != nil {
+		delay = l.global.reserve(now)
+	}
+	if config.RateLimit.SubnetRate > 0 {
+		subnet := subnetOf(host)
+		bucket, ok := l.subnets[subnet]
+		if !ok {
+			if len(l.subnets) >= subnetSweepSize {
+				for s, b := range l.subnets {
+					if b.idle(now) {
+						delete(l.subnets, s)
+					}
+				}
+			}
+			bucket = newTokenBucket(config.RateLimit.SubnetRate, config.RateLimit.SubnetBurst, now)
+			l.subnets[subnet] = bucket
+		}
+		if d := bucket.reserve(now); d > delay {
+			delay = d
+		}
+	}
+	l.conns++
+	if delay > 0 {
+		l.delayed++
+		l.waited += delay
+	}
+	l.mu.Unlock()
+	return sleepContext(ctx, delay)
+}
+
+//summary describe the limits and how often they applied
+func (l *connLimiter) summary() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fmt.Sprintf("rate limit: %g conn/s, %g conn/s per subnet, connections: %d, delayed: %d, waited: %s",
+		config.RateLimit.Rate, config.RateLimit.SubnetRate, l.conns, l.delayed, l.waited.Round(time.Millisecond))
+}
+
+//limitedDialer wait for the rate limit before dialing
+type limitedDialer struct {
+	forward proxy.ContextDialer
+}
+
+//Dial wait for the rate limit of the host of addr and dial it
+func (d limitedDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+//DialContext wait for the rate limit of the host of addr and dial it
+func (d limitedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if err = limiter.wait(ctx, host); err != nil {
+		return nil, err
+	}
+	return d.forward.DialContext(ctx, network, addr)
+}
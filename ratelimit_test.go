@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	b := newTokenBucket(10, 3, t0)
+	//the burst is available at once
+	for i := 0; i < 3; i++ {
+		if d := b.reserve(t0); d != 0 {
+			t.Fatalf("reserve %d = %s, want 0", i, d)
+		}
+	}
+	//then one token per 100ms
+	if d := b.reserve(t0); d != 100*time.Millisecond {
+		t.Errorf("reserve = %s, want 100ms", d)
+	}
+	if d := b.reserve(t0); d != 200*time.Millisecond {
+		t.Errorf("reserve = %s, want 200ms", d)
+	}
+	if b.idle(t0.Add(400 * time.Millisecond)) {
+		t.Error("bucket is idle before it refilled")
+	}
+	if !b.idle(t0.Add(500 * time.Millisecond)) {
+		t.Error("bucket is not idle after it refilled")
+	}
+	//the tokens never exceed the burst
+	t1 := t0.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.reserve(t1)
+	}
+	if d := b.reserve(t1); d != 100*time.Millisecond {
+		t.Errorf("reserve after an hour = %s, want 100ms", d)
+	}
+}
+
+func TestTokenBucketMinBurst(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	b := newTokenBucket(2, 0, t0)
+	if d := b.reserve(t0); d != 0 {
+		t.Errorf("first reserve = %s, want 0", d)
+	}
+	if d := b.reserve(t0); d != 500*time.Millisecond {
+		t.Errorf("second reserve = %s, want 500ms", d)
+	}
+	if d := b.reserve(t0.Add(500 * time.Millisecond)); d != 500*time.Millisecond {
+		t.Errorf("third reserve = %s, want 500ms", d)
+	}
+}
+
+func TestSubnetOf(t *testing.T) {
+	tests := []struct {
+		host, want string
+	}{
+		{"216.58.200.4", "216.58.200.0"},
+		{"216.58.200.255", "216.58.200.0"},
+		{"2404:6800:4008:c01::8a", "2404:6800:4008::"},
+		{"2404:6800:4008:ffff::1", "2404:6800:4008::"},
+		{"not-an-ip", "not-an-ip"},
+	}
+	for _, tt := range tests {
+		if got := subnetOf(tt.host); got != tt.want {
+			t.Errorf("subnetOf(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const resumeProberName = "resume"
+
+//resumeTicketWait how long to wait for the session ticket after a handshake
+const resumeTicketWait = 500 * time.Millisecond
+
+//Resume check tls session resumption by a second connection
+type Resume struct {
+	Enabled bool `json:"enabled"`
+}
+
+//ResumeResult result of the resumed handshake with an ip. OK is false if
+//the server did a full handshake again.
+type ResumeResult struct {
+	Checked bool
+	OK      bool
+	Delay   int
+}
+
+//resumeProber record whether ip resumes tls sessions, it never rejects an ip
+type resumeProber struct{}
+
+func init() {
+	registerProber(resumeProber{})
+}
+
+//initResume check the options depending on the resume prober, it must be
+//called after initProbers
+func initResume() {
+	if config.DelayMetric == delayResumed && !proberEnabled(resumeProberName) {
+		checkErr("parse config file error: ", fmt.Errorf("delay_metric %q needs check_resume", delayResumed), Error)
+	}
+}
+
+//Name of resumeProber
+func (resumeProber) Name() string {
+	return resumeProberName
+}
+
+//Probe handshake twice with p.IP sharing a session cache
+func (resumeProber) Probe(ctx context.Context, p *Probe) (int, error) {
+	p.IP.Resume = checkResume(ctx, p.Config, p.Dialer, p.IP.Address, p.SNI)
+	return okIP, nil
+}
+
+//checkResume do a full handshake with ip to get a session ticket, then a
+//second handshake resuming it
+func checkResume(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip, sni string) ResumeResult {
+	result := ResumeResult{Checked: true}
+	c := sniTLSConfigs[sni].Clone()
+	c.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+	conn, _, _, err := dialTLS(ctx, cfg, dialer, ip, c)
+	if err != nil {
+		checkErr(fmt.Sprintf("%s resume: ", ip), err, Debug)
+		return result
+	}
+	//tls 1.3 tickets are sent after the handshake and only processed when
+	//reading
+	if conn.ConnectionState().Version >= tls.VersionTLS13 {
+		conn.SetReadDeadline(time.Now().Add(resumeTicketWait))
+		conn.Read(make([]byte, 1))
+	}
+	conn.Close()
+
+	conn, _, handshake, err := dialTLS(ctx, cfg, dialer, ip, c)
+	if err != nil {
+		checkErr(fmt.Sprintf("%s resume: ", ip), err, Debug)
+		return result
+	}
+	defer conn.Close()
+	result.OK = conn.ConnectionState().DidResume
+	result.Delay = toMillisecond(handshake)
+	return result
+}
+
+//ticketCache a session cache which only reports the session tickets
+//received, it never resumes
+type ticketCache struct {
+	tickets chan *tls.SessionState
+}
+
+func newTicketCache() *ticketCache {
+	return &ticketCache{tickets: make(chan *tls.SessionState, 1)}
+}
+
+//Get never returns a session
+func (c *ticketCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	return nil, false
+}
+
+//Put report the session state of the ticket
+func (c *ticketCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	if cs == nil {
+		return
+	}
+	if _, state, err := cs.ResumptionState(); err == nil && state != nil {
+		select {
+		case c.tickets <- state:
+		default:
+		}
+	}
+}
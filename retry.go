@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//failure class of a probe
+const (
+	failTimeout      = "timeout"
+	failRefused      = "refused"
+	failReset        = "reset"
+	failTLSAlert     = "tls_alert"
+	failEOF          = "eof"
+	failNoCert       = "no_cert"
+	failCertMismatch = "cert_mismatch"
+	failIntercepted  = "intercepted"
+	failCanceled     = "canceled" //the scan was stopped, never retried
+	failOther        = "other"
+)
+
+//RetryPolicy retry a failure class up to Times times, waiting Backoff
+//milliseconds before the first retry and doubling it for every next one.
+type RetryPolicy struct {
+	Times   int `json:"times"`
+	Backoff int `json:"backoff"`
+}
+
+//classifyError return the failure class of err
+func classifyError(err error) string {
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return failCanceled
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return failTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED):
+		return failReset
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		//crypto/tls reports alerts sent by the server this way
+		return failTLSAlert
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return failEOF
+	}
+	return failOther
+}
+
+//checkRetryPolicy check the configured failure classes
+func checkRetryPolicy() {
+	for class := range config.Retry {
+		switch class {
+		case failTimeout, failRefused, failReset, failTLSAlert, failEOF, failOther:
+		default:
+			checkErr("parse config file error: ", fmt.Errorf("unknown retry class %q", class), Error)
+		}
+	}
+}
+
+//handshakeIPWithRetry call handshakeIP, retrying transient failures by the
+//policy of their failure class. It also returns the number of retries.
+func handshakeIPWithRetry(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip, sni string) (tlsClient *tls.Conn, connect, handshake time.Duration, retries int, err error) {
+	for {
+		tlsClient, connect, handshake, err = handshakeIP(ctx, cfg, dialer, ip, sni)
+		if err == nil {
+			return tlsClient, connect, handshake, retries, nil
+		}
+		policy := cfg.Retry[classifyError(err)]
+		if retries >= policy.Times {
+			return nil, connect, 0, retries, err
+		}
+		if sleepContext(ctx, time.Millisecond*time.Duration(policy.Backoff<<uint(retries))) != nil {
+			return nil, connect, 0, retries, err
+		}
+		retries++
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//delay statistic used for sorting and the delay cutoff
+const (
+	statFirst  = "first"
+	statMin    = "min"
+	statAvg    = "avg"
+	statMedian = "median"
+	statMax    = "max"
+	statStdDev = "stddev"
+)
+
+//DelayStats statistics of the delay samples of an ip, in milliseconds.
+//Loss is the fraction of failed attempts.
+type DelayStats struct {
+	Samples int
+	Min     int
+	Avg     int
+	Median  int
+	Max     int
+	StdDev  int
+	Loss    float64
+}
+
+//warmupIP make a connection whose result is thrown away, so the samples
+//are not skewed by a cold path
+func warmupIP(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip string) {
+	if conn, _, _, err := handshakeIP(ctx, cfg, dialer, ip, sniList[0]); err == nil {
+		conn.Close()
+	}
+}
+
+//sampleIP handshake with ip until there are samples.count attempts, first
+//is the delay of the handshake already done. Sampling stops when ctx is
+//done.
+func sampleIP(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip, sni string, first int) DelayStats {
+	delays := []int{first}
+	failed := 0
+	for i := 1; i < cfg.Samples.Count && ctx.Err() == nil; i++ {
+		conn, connect, handshake, err := handshakeIP(ctx, cfg, dialer, ip, sni)
+		if err != nil {
+			failed++
+			continue
+		}
+		delay := metricDelayOf(cfg, connect, handshake)
+		if cfg.DelayMetric == delayRTT {
+			if info, ok := tcpInfoOf(cfg, conn.NetConn()); ok {
+				delay = info.rttDelay()
+			}
+		}
+		conn.Close()
+		delays = append(delays, delay)
+	}
+	stats := newDelayStats(delays)
+	stats.Loss = float64(failed) / float64(cfg.Samples.Count)
+	return stats
+}
+
+//metricDelayOf return the delay chosen by delay_metric
+func metricDelayOf(cfg *Config, connect, handshake time.Duration) int {
+	switch cfg.DelayMetric {
+	case delayConnect:
+		return toMillisecond(connect)
+	case delayTotal:
+		return toMillisecond(connect + handshake)
+	}
+	return toMillisecond(handshake)
+}
+
+//newDelayStats compute the statistics of delays
+func newDelayStats(delays []int) DelayStats {
+	stats := DelayStats{Samples: len(delays)}
+	if len(delays) == 0 {
+		return stats
+	}
+	sorted := append([]int(nil), delays...)
+	sort.Ints(sorted)
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	if n := len(sorted); n%2 == 1 {
+		stats.Median = sorted[n/2]
+	} else {
+		stats.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	sum := 0
+	for _, d := range delays {
+		sum += d
+	}
+	avg := float64(sum) / float64(len(delays))
+	variance := 0.0
+	for _, d := range delays {
+		variance += (float64(d) - avg) * (float64(d) - avg)
+	}
+	stats.Avg = int(avg + 0.5)
+	stats.StdDev = int(math.Sqrt(variance/float64(len(delays))) + 0.5)
+	return stats
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//sniNone means handshake without SNI
+const sniNone = "none"
+
+//defaultPort the port ips are checked on unless port is configured
+const defaultPort = 443
+
+//How the SNI results decide whether an ip is ok
+const (
+	//the first SNI decides, the others are only recorded
+	sniSelectFirst = "first"
+	//the first SNI which handshakes successfully decides
+	sniSelectAny = "any"
+	//every SNI must handshake successfully, the first one decides
+	sniSelectAll = "all"
+)
+
+//initSNI prepare a tls config for every configured SNI
+func initSNI() {
+	sniList = config.SNI
+	if len(sniList) == 0 {
+		sniList = []string{sniNone}
+	}
+	switch config.SNISelect {
+	case sniSelectFirst, sniSelectAny, sniSelectAll:
+	case "":
+		config.SNISelect = sniSelectFirst
+	default:
+		checkErr("parse config file error: ", fmt.Errorf("unknown sni_select %q", config.SNISelect), Error)
+	}
+
+	sniTLSConfigs = make(map[string]*tls.Config)
+	for _, sni := range sniList {
+		c := tlsConfig
+		if sni != sniNone {
+			c = tlsConfig.Clone()
+			c.ServerName = sni
+		}
+		sniTLSConfigs[sni] = c
+	}
+}
+
+//tlsAddr return the address of ip on the configured port
+func tlsAddr(cfg *Config, ip string) string {
+	port := cfg.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+//handshakeIP dial ip with dialer and do tls handshake with the given SNI,
+//it returns the tcp connect time and the handshake time.
+func handshakeIP(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip, sni string) (tlsClient *tls.Conn, connect, handshake time.Duration, err error) {
+	return dialTLS(ctx, cfg, dialer, ip, sniTLSConfigs[sni])
+}
+
+//dialTLS dial ip with dialer and do tls handshake with config c, both are
+//aborted when ctx is done
+func dialTLS(ctx context.Context, cfg *Config, dialer proxy.ContextDialer, ip string, c *tls.Config) (tlsClient *tls.Conn, connect, handshake time.Duration, err error) {
+	t0 := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", tlsAddr(cfg, ip))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("dial error: %w", err)
+	}
+	t1 := time.Now()
+
+	tlsClient = tls.Client(conn, c)
+	tlsClient.SetDeadline(time.Now().Add(time.Millisecond * time.Duration(cfg.HandshakeTimeout)))
+	if err = tlsClient.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, t1.Sub(t0), 0, fmt.Errorf("handshake error: %w", err)
+	}
+	return tlsClient, t1.Sub(t0), time.Since(t1), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+//sniProxyClass the class of ips which serve unrelated SNIs with their own
+//certificates, they are never exported to the gws/gvs pools
+const sniProxyClass = "sniproxy"
+
+//sniProxyIPFileName the list of detected sni proxies
+const sniProxyIPFileName = "ip_sniproxy.txt"
+
+const sniProxyProberName = "sniproxy"
+
+//SNIProxy detect generic sni proxies by handshaking with unrelated SNIs
+type SNIProxy struct {
+	Enabled     bool     `json:"enabled"`
+	ServerNames []string `json:"server_names"`
+	MinAccepted int      `json:"min_accepted"`
+}
+
+//sniProxyProber handshake with the unrelated SNIs and mark the ip as sni
+//proxy if enough of them are served with a matching certificate
+type sniProxyProber struct{}
+
+func init() {
+	registerProber(sniProxyProber{})
+}
+
+//initSNIProxy prepare the tls configs of the unrelated SNIs, it must be
+//called after initProbers
+func initSNIProxy() {
+	if !proberEnabled(sniProxyProberName) {
+		return
+	}
+	if len(config.SNIProxy.ServerNames) == 0 {
+		checkErr("parse config file error: ", fmt.Errorf("sni_proxy needs server_names"), Error)
+	}
+	if config.SNIProxy.MinAccepted <= 0 {
+		config.SNIProxy.MinAccepted = 1
+	}
+	for _, sni := range config.SNIProxy.ServerNames {
+		if _, ok := sniTLSConfigs[sni]; !ok {
+			c := tlsConfig.Clone()
+			c.ServerName = sni
+			sniTLSConfigs[sni] = c
+		}
+	}
+	ruleClasses = append(ruleClasses, sniProxyClass)
+}
+
+//Name of sniProxyProber
+func (sniProxyProber) Name() string {
+	return sniProxyProberName
+}
+
+//Probe handshake with every unrelated SNI, an ip is a sni proxy if at least
+//min_accepted of them return a certificate valid for the SNI
+func (sniProxyProber) Probe(ctx context.Context, p *Probe) (int, error) {
+	accepted := 0
+	for _, sni := range p.Config.SNIProxy.ServerNames {
+		if ctx.Err() != nil {
+			return noIP, ctx.Err()
+		}
+		conn, _, handshake, err := handshakeIP(ctx, p.Config, p.Dialer, p.IP.Address, sni)
+		result := SNIResult{ServerName: sni, Delay: toMillisecond(handshake)}
+		if err != nil {
+			checkErr(fmt.Sprintf("%s proxy sni %s: ", p.IP.Address, sni), err, Debug)
+		} else {
+			result.OK = true
+			if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+				result.CommonName = certs[0].Subject.CommonName
+				if certs[0].VerifyHostname(sni) == nil {
+					accepted++
+				}
+			}
+			conn.Close()
+		}
+		p.IP.ProxySNI = append(p.IP.ProxySNI, result)
+	}
+	if accepted >= p.Config.SNIProxy.MinAccepted {
+		p.IP.ServerName = sniProxyClass
+		//the rules which would fill in a SAN are skipped
+		if p.IP.CommonName == "" {
+			p.IP.CommonName = "-"
+		}
+	}
+	return okIP, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//defaultGracePeriod seconds the probes in flight may finish in after an
+//interrupt
+const defaultGracePeriod = 10
+
+//scanCtx is done once the scan is stopped by stopScan or by a signal after
+//the grace period, everything in flight is cancelled
+var scanCtx, stopScan = context.WithCancel(context.Background())
+
+//dispatchCtx is done once no more ips should be dispatched, by a signal or
+//a stop condition such as a full ip pool. The probes in flight keep running.
+var dispatchCtx, stopDispatch = context.WithCancel(scanCtx)
+
+//interrupted is set by the first signal
+var interrupted atomic.Bool
+
+//handleSignals stop dispatching on the first SIGINT or SIGTERM and cancel
+//the probes still in flight after the grace period, so the results found
+//so far are written as usual. The second signal exits at once.
+func handleSignals() {
+	grace := config.GracePeriod
+	if grace <= 0 {
+		grace = defaultGracePeriod
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Printf("\nstopping scan, waiting up to %ds for the ips being checked, interrupt again to exit now\n", grace)
+		interrupted.Store(true)
+		stopDispatch()
+		time.AfterFunc(time.Duration(grace)*time.Second, stopScan)
+		<-sigs
+		fmt.Println("\nexit without writing results")
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+)
+
+//TCPInfo the kernel view of the tcp connection to an ip, in microseconds
+type TCPInfo struct {
+	Checked     bool
+	RTT         int
+	RTTVar      int
+	Retransmits int
+}
+
+//rttDelay the smoothed rtt in milliseconds, rounded up so a sub
+//millisecond rtt is not taken as no delay
+func (info TCPInfo) rttDelay() int {
+	return (info.RTT + 999) / 1000
+}
+
+//tcpInfoOf read TCP_INFO of the connection to an ip. It is skipped when
+//proxies are configured, the socket then leads to the first proxy and its
+//rtt is not the rtt of the ip.
+func tcpInfoOf(cfg *Config, conn net.Conn) (TCPInfo, bool) {
+	if len(cfg.Proxies) > 0 {
+		return TCPInfo{}, false
+	}
+	return readTCPInfo(conn)
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+//tcpInfoSupported whether readTCPInfo can read TCP_INFO
+const tcpInfoSupported = true
+
+//readTCPInfo read TCP_INFO of conn, it fails if conn is not a socket, e.g.
+//a connection through a SOCKS5 proxy. A connection through an HTTP CONNECT
+//proxy is the socket to the proxy, see tcpInfoOf.
+func readTCPInfo(conn net.Conn) (TCPInfo, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return TCPInfo{}, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return TCPInfo{}, false
+	}
+	var info *unix.TCPInfo
+	cerr := raw.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if cerr != nil || err != nil {
+		return TCPInfo{}, false
+	}
+	return TCPInfo{
+		Checked:     true,
+		RTT:         int(info.Rtt),
+		RTTVar:      int(info.Rttvar),
+		Retransmits: int(info.Total_retrans),
+	}, true
+}
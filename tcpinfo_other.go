@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+)
+
+//tcpInfoSupported whether readTCPInfo can read TCP_INFO
+const tcpInfoSupported = false
+
+//readTCPInfo TCP_INFO is only read on linux
+func readTCPInfo(conn net.Conn) (TCPInfo, bool) {
+	return TCPInfo{}, false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+//oidSCTList the certificate extension of embedded SCTs, RFC 6962 section 3.3
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+//TLSDetails the negotiated tls session and the leaf certificate of an ip
+type TLSDetails struct {
+	Version     string
+	CipherSuite string
+	Group       string
+	Fingerprint string //hex sha256 of the leaf certificate
+	SPKIHash    string //base64 sha256 of the leaf SubjectPublicKeyInfo
+	Issuer      string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	SANCount    int
+	OCSPStapled bool
+	SCT         bool
+}
+
+//newTLSDetails collect the details of state, which must have peer
+//certificates
+func newTLSDetails(state tls.ConnectionState) TLSDetails {
+	leaf := state.PeerCertificates[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	details := TLSDetails{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		SPKIHash:    base64.StdEncoding.EncodeToString(spki[:]),
+		Issuer:      leaf.Issuer.CommonName,
+		NotBefore:   leaf.NotBefore.UTC(),
+		NotAfter:    leaf.NotAfter.UTC(),
+		SANCount:    len(leaf.DNSNames) + len(leaf.IPAddresses),
+		OCSPStapled: len(state.OCSPResponse) > 0,
+		SCT:         len(state.SignedCertificateTimestamps) > 0,
+	}
+	if state.CurveID != 0 {
+		details.Group = state.CurveID.String()
+	}
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			details.SCT = true
+		}
+	}
+	return details
+}
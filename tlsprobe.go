@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//tlsProber handshake with the configured SNIs and check the peer
+//certificate
+type tlsProber struct{}
+
+func init() {
+	registerProber(tlsProber{})
+}
+
+//Name of tlsProber
+func (tlsProber) Name() string {
+	return tlsProberName
+}
+
+//Probe handshake with p.IP and check its certificate
+func (tlsProber) Probe(ctx context.Context, p *Probe) (int, error) {
+	checkedip := &p.IP
+	cfg := p.Config
+	if cfg.Samples.Warmup {
+		warmupIP(ctx, cfg, p.Dialer, checkedip.Address)
+	}
+
+	var err error
+	for i, sni := range sniList {
+		conn, connect, handshake, retries, herr := handshakeIPWithRetry(ctx, cfg, p.Dialer, checkedip.Address, sni)
+		checkedip.Retries += retries
+		result := SNIResult{ServerName: sni, Delay: toMillisecond(handshake)}
+		if herr != nil {
+			checkErr(fmt.Sprintf("%s sni %s: ", checkedip.Address, sni), herr, Debug)
+			err = herr
+			//the handshake failed on a live ip, which the adaptive
+			//concurrency tells apart from a dead one
+			if p.Conn == nil && connect > 0 {
+				checkedip.ConnectDelay = toMillisecond(connect)
+			}
+		} else {
+			result.OK = true
+			if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+				result.CommonName = certs[0].Subject.CommonName
+			}
+		}
+		checkedip.SNIResults = append(checkedip.SNIResults, result)
+
+		if herr == nil && p.Conn == nil && (i == 0 || cfg.SNISelect != sniSelectFirst) {
+			p.Conn = conn
+			p.SNI = sni
+			checkedip.Delay = result.Delay
+			checkedip.ConnectDelay = toMillisecond(connect)
+			checkedip.TotalDelay = toMillisecond(connect + handshake)
+			checkedip.TCPInfo, _ = tcpInfoOf(cfg, conn.NetConn())
+		} else if herr == nil {
+			conn.Close()
+		} else if cfg.SNISelect == sniSelectAll {
+			p.Close()
+			p.Conn = nil
+			break
+		}
+	}
+	if p.Conn == nil {
+		return errIP, err
+	}
+
+	state := p.Conn.ConnectionState()
+	if state.PeerCertificates == nil {
+		checkedip.FailureClass = failNoCert
+		return noIP, errors.New("peer certificates is nil")
+	}
+
+	if cfg.Pinning.Enabled {
+		if err = checkPins(state.PeerCertificates); err != nil {
+			checkedip.FailureClass = failIntercepted
+			saveChain(cfg, checkedip.Address, state.PeerCertificates)
+			return interceptedIP, err
+		}
+	}
+
+	if cfg.VerifyCert.Mode != verifyOff {
+		err = verifyCert(cfg, state, p.SNI)
+		checkedip.CertVerified = err == nil
+		if err != nil {
+			checkedip.CertVerifyError = err.Error()
+			checkedip.FailureClass = failCertMismatch
+			return noIP, fmt.Errorf("verify certificate error: %v", err)
+		}
+	}
+
+	checkedip.TLS = newTLSDetails(state)
+	if state.NegotiatedProtocol != "" {
+		checkedip.Protocol = state.NegotiatedProtocol
+	}
+
+	peerCertSubject := state.PeerCertificates[0].Subject
+	checkedip.CommonName = peerCertSubject.CommonName
+	if len(peerCertSubject.Organization) > 0 {
+		checkedip.OrgName = peerCertSubject.Organization[0]
+	}
+	if len(peerCertSubject.Country) > 0 {
+		checkedip.CountryName = peerCertSubject.Country[0]
+	}
+	if cfg.Samples.Count > 1 {
+		checkedip.Stats = sampleIP(ctx, cfg, p.Dialer, checkedip.Address, p.SNI, checkedip.metricDelay(cfg))
+	}
+	return okIP, nil
+}
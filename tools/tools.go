@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -82,8 +83,8 @@ func convertIP2JSON() {
 	// 		}
 	// 		isAllBandwidth = false
 	// 	}
-	gws, gvs := writeJSONIP2File(delay, bandwidth, isGWS, isAllBandwidth, isAll)
-	fmt.Printf("\ndelay: %dms, ip count: %d(gws: %d, gvs: %d)\n", delay, gws+gvs, gws, gvs)
+	total, classes := writeJSONIP2File(delay, bandwidth, isGWS, isAllBandwidth, isAll)
+	fmt.Printf("\ndelay: %dms, ip count: %d(%s)\n", delay, total, formatClassCount(classes))
 
 	fmt.Println("\npress Enter to continue...")
 	fmt.Scanln()
@@ -155,7 +156,7 @@ func getLastOkIP() []IP {
 		lines := strings.Split(string(bytes), "\n")
 		for _, line := range lines {
 			ipInfo := strings.Split(line, " ")
-			if len(ipInfo) == 6 || len(ipInfo) == 5 {
+			if len(ipInfo) >= 5 {
 				delay, _ := strconv.Atoi(ipInfo[1][:len(ipInfo[1])-2])
 				// bandwidth, err := strconv.Atoi(ipInfo[5][:len(ipInfo[5])-4])
 				// if err != nil {
@@ -181,8 +182,9 @@ func getLastOkIP() []IP {
 writeJSONIP2File: sorting ip, ridding duplicate ip, generating json ip and
 bar-separated ip
 */
-func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll bool) (gws, gvs int) {
+func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll bool) (total int, classes map[string]int) {
 	okIPs := getLastOkIP()
+	classes = make(map[string]int)
 	_, err := os.Create(jsonIPFileName)
 	if err != nil {
 		fmt.Printf("create file %s error: %v", jsonIPFileName, err)
@@ -193,7 +195,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 			if isGWS {
 				if ip.ServerName == "gws" {
 					if isAll {
-						gws++
+						classes[ip.ServerName]++
 						gaipbuf.WriteString(ip.Address)
 						gaipbuf.WriteString("|")
 						gpipbuf.WriteString("\"")
@@ -201,7 +203,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 						gpipbuf.WriteString("\",")
 					} else {
 						if ip.Delay <= delay {
-							gws++
+							classes[ip.ServerName]++
 							gaipbuf.WriteString(ip.Address)
 							gaipbuf.WriteString("|")
 							gpipbuf.WriteString("\"")
@@ -212,12 +214,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 				}
 			} else {
 				if isAll {
-					if ip.ServerName == "gws" {
-						gws++
-					}
-					if ip.ServerName == "gvs" {
-						gvs++
-					}
+					classes[ip.ServerName]++
 					gaipbuf.WriteString(ip.Address)
 					gaipbuf.WriteString("|")
 					gpipbuf.WriteString("\"")
@@ -225,12 +222,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 					gpipbuf.WriteString("\",")
 				} else {
 					if ip.Delay <= delay {
-						if ip.ServerName == "gws" {
-							gws++
-						}
-						if ip.ServerName == "gvs" {
-							gvs++
-						}
+						classes[ip.ServerName]++
 						gaipbuf.WriteString(ip.Address)
 						gaipbuf.WriteString("|")
 						gpipbuf.WriteString("\"")
@@ -244,7 +236,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 				if isGWS {
 					if ip.ServerName == "gws" {
 						if isAll {
-							gws++
+							classes[ip.ServerName]++
 							gaipbuf.WriteString(ip.Address)
 							gaipbuf.WriteString("|")
 							gpipbuf.WriteString("\"")
@@ -252,7 +244,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 							gpipbuf.WriteString("\",")
 						} else {
 							if ip.Delay <= delay {
-								gws++
+								classes[ip.ServerName]++
 								gaipbuf.WriteString(ip.Address)
 								gaipbuf.WriteString("|")
 								gpipbuf.WriteString("\"")
@@ -263,12 +255,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 					}
 				} else {
 					if isAll {
-						if ip.ServerName == "gws" {
-							gws++
-						}
-						if ip.ServerName == "gvs" {
-							gvs++
-						}
+						classes[ip.ServerName]++
 						gaipbuf.WriteString(ip.Address)
 						gaipbuf.WriteString("|")
 						gpipbuf.WriteString("\"")
@@ -276,12 +263,7 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 						gpipbuf.WriteString("\",")
 					} else {
 						if ip.Delay <= delay {
-							if ip.ServerName == "gws" {
-								gws++
-							}
-							if ip.ServerName == "gvs" {
-								gvs++
-							}
+							classes[ip.ServerName]++
 							gaipbuf.WriteString(ip.Address)
 							gaipbuf.WriteString("|")
 							gpipbuf.WriteString("\"")
@@ -306,7 +288,24 @@ func writeJSONIP2File(delay int, bandwidth int, isGWS, isAllBandwidth, isAll boo
 	if err != nil {
 		fmt.Printf("write ip to file %s error: %v", jsonIPFileName, err)
 	}
-	return gws, gvs
+	for _, count := range classes {
+		total += count
+	}
+	return total, classes
+}
+
+//formatClassCount format the number of ip of every class in ip_tmpok.txt,
+//like "gvs: 2, gws: 1"
+func formatClassCount(count map[string]int) string {
+	var classes []string
+	for class := range count {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for i, class := range classes {
+		classes[i] = class + ": " + strconv.Itoa(count[class])
+	}
+	return strings.Join(classes, ", ")
 }
 
 //Whether file exists.
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//Uplink a local network path for probes, chosen by source ip or network
+//interface. If several uplinks are configured every ip is checked over each
+//of them and the results are tagged with the uplink name.
+type Uplink struct {
+	Name      string `json:"name"`
+	SourceIP  string `json:"source_ip"`
+	Interface string `json:"interface"`
+
+	localIP net.IP
+	control func(network, address string, c syscall.RawConn) error
+}
+
+var uplinks []*Uplink
+
+var sourceIP = flag.String("source", "", "local source ip of probes, overrides uplinks in config")
+var bindInterface = flag.String("interface", "", "network interface of probes, overrides uplinks in config")
+
+//initUplinks resolve the source of every uplink, it must be called after
+//the flags are parsed. Uplinks are not changed after that.
+func initUplinks() {
+	configured := config.Uplinks
+	if *sourceIP != "" || *bindInterface != "" {
+		configured = []Uplink{{SourceIP: *sourceIP, Interface: *bindInterface}}
+	}
+	if len(configured) == 0 {
+		configured = []Uplink{{}}
+	}
+
+	names := make(map[string]bool)
+	for i := range configured {
+		uplink := configured[i]
+		if len(configured) > 1 {
+			if uplink.Name == "" || names[uplink.Name] {
+				checkErr("parse config file error: ", fmt.Errorf("uplink %d needs a unique name", i), Error)
+			}
+			names[uplink.Name] = true
+		}
+		if uplink.SourceIP != "" {
+			uplink.localIP = net.ParseIP(uplink.SourceIP)
+			if uplink.localIP == nil {
+				checkErr("parse config file error: ", fmt.Errorf("invalid source ip %q", uplink.SourceIP), Error)
+			}
+		}
+		if uplink.Interface != "" {
+			err := bindToInterface(&uplink)
+			checkErr(fmt.Sprintf("bind to interface %s error: ", uplink.Interface), err, Error)
+		}
+		uplinks = append(uplinks, &uplink)
+	}
+}
+
+//newDialer return a dialer for one probe over uplink, going through the
+//proxy chain and the rate limit
+func (uplink *Uplink) newDialer(cfg *Config) proxy.ContextDialer {
+	d := &net.Dialer{
+		Timeout:   time.Millisecond * time.Duration(cfg.Timeout),
+		KeepAlive: 0,
+		Control:   uplink.control,
+	}
+	if uplink.localIP != nil {
+		d.LocalAddr = &net.TCPAddr{IP: uplink.localIP}
+	}
+	dialer := proxyChain(cfg, d)
+	if limiter != nil {
+		dialer = limitedDialer{dialer}
+	}
+	return dialer
+}
+
+//uplinkByName return the uplink with name, or the first one if there is
+//no such uplink
+func uplinkByName(name string) *Uplink {
+	for _, uplink := range uplinks {
+		if uplink.Name == name {
+			return uplink
+		}
+	}
+	return uplinks[0]
+}
+
+//uplinkIPFileName return the ip.txt of uplink
+func uplinkIPFileName(uplink *Uplink) string {
+	return fmt.Sprintf("ip_%s.txt", uplink.Name)
+}
+
+//listenUDP open a udp socket bound like the tcp probes of uplink
+func (uplink *Uplink) listenUDP(ctx context.Context) (net.PacketConn, error) {
+	laddr := ":0"
+	if uplink.localIP != nil {
+		laddr = net.JoinHostPort(uplink.localIP.String(), "0")
+	}
+	lc := net.ListenConfig{Control: uplink.control}
+	return lc.ListenPacket(ctx, "udp", laddr)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"os"
+	"time"
 
 	"github.com/golang/glog"
 )
@@ -26,6 +28,22 @@ func checkErr(messge string, err error, level int) {
 	}
 }
 
+//sleepContext sleep for d, it returns early with the error of ctx once ctx
+//is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 //Whether file exists.
 func isFileExist(file string) bool {
 	_, err := os.Stat(file)
@@ -52,3 +70,8 @@ func convertArray2Map(s []string) (m map[string]string) {
 	}
 	return m
 }
+
+//convert duration to milliseconds
+func toMillisecond(d time.Duration) int {
+	return int(d.Seconds() * 1000)
+}
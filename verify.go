@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+//certificate verification mode
+const (
+	//do not verify, only match the certificate names
+	verifyOff = "off"
+	//verify the chain against cacert.pem or system roots
+	verifyChain = "chain"
+	//verify the chain and the hostname
+	verifyHostname = "hostname"
+)
+
+var verifyRoots *x509.CertPool
+
+//initVerifyCert check verify mode and choose the root pool
+func initVerifyCert() {
+	switch config.VerifyCert.Mode {
+	case verifyChain, verifyHostname, verifyOff:
+	case "":
+		config.VerifyCert.Mode = verifyOff
+	default:
+		checkErr("parse config file error: ", fmt.Errorf("unknown verify_cert mode %q", config.VerifyCert.Mode), Error)
+	}
+	//without a hostname the SNI is verified, which "none" does not have
+	if config.VerifyCert.Mode == verifyHostname && config.VerifyCert.Hostname == "" {
+		for _, sni := range sniList {
+			if sni == sniNone {
+				checkErr("parse config file error: ", fmt.Errorf("verify_cert hostname is empty and sni %q has no name to verify instead", sniNone), Error)
+			}
+		}
+	}
+	//nil roots means system roots
+	if !config.VerifyCert.SystemRoots {
+		verifyRoots = certPool
+	}
+}
+
+//verifyCert verify the peer certificates of state, sni is the SNI sent in
+//handshake which is used as hostname if none is configured.
+func verifyCert(cfg *Config, state tls.ConnectionState, sni string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("peer certificates is nil")
+	}
+	opts := chainOptions(state.PeerCertificates)
+	if cfg.VerifyCert.Mode == verifyHostname {
+		opts.DNSName = cfg.VerifyCert.Hostname
+		if opts.DNSName == "" && sni != sniNone {
+			opts.DNSName = sni
+		}
+		if opts.DNSName == "" {
+			return errors.New("no hostname to verify, set verify_cert hostname or sni")
+		}
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+//chainOptions return the options to verify chain against cacert.pem or the
+//system roots, the presented certificates after the leaf are intermediates
+func chainOptions(chain []*x509.Certificate) x509.VerifyOptions {
+	opts := x509.VerifyOptions{
+		Roots:         verifyRoots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return opts
+}